@@ -0,0 +1,170 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"rflite/internal/executer"
+	"rflite/internal/raft"
+	"rflite/internal/sql"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// errMixedStatements is a route_writes array with reads and writes, which
+// can neither run as a query nor be applied as one write without losing
+// the rows of the reads
+var errMixedStatements = errors.New("route_writes needs every statement to write")
+
+// jsonBody is the body of a JSON request to /query or /exec, the
+// statements to run in order
+type jsonBody struct {
+	Statements []jsonStatement `json:"statements"`
+	// ContinueOnError keeps running the statements of an exec after one
+	// failed
+	ContinueOnError bool `json:"continue_on_error"`
+}
+
+type jsonStatement struct {
+	Q      string      `json:"q"`
+	Params []sql.Param `json:"params"`
+}
+
+// isJSON reports whether the request has a JSON body instead of a form
+func isJSON(c *gin.Context) bool {
+	return c.ContentType() == "application/json"
+}
+
+// decodeJSONBody reads the JSON body of a request. The body is put back,
+// so the request can still be proxied to the leader afterwards.
+func decodeJSONBody(c *gin.Context) (jsonBody, error) {
+	var body jsonBody
+	raw, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		return body, err
+	}
+	c.Request.Body = io.NopCloser(bytes.NewReader(raw))
+	if err := json.Unmarshal(raw, &body); err != nil {
+		return body, fmt.Errorf("invalid body: %w", err)
+	}
+	if len(body.Statements) == 0 {
+		return body, errors.New("no statements")
+	}
+	return body, nil
+}
+
+func (b jsonBody) statements() []sql.Statement {
+	stmts := make([]sql.Statement, len(b.Statements))
+	for i, s := range b.Statements {
+		stmts[i] = sql.Statement{SQL: s.Q, Params: s.Params}
+	}
+	return stmts
+}
+
+// requestCommand builds the command of an exec request from its JSON
+// body or its form
+func requestCommand(c *gin.Context) (raft.Command, error) {
+	if !isJSON(c) {
+		return formCommand(c)
+	}
+	body, err := decodeJSONBody(c)
+	if err != nil {
+		return raft.Command{}, err
+	}
+	return newCommand(body.statements(), body.ContinueOnError), nil
+}
+
+// newCommand runs a single statement on its own and several in one
+// transaction
+func newCommand(stmts []sql.Statement, continueOnError bool) raft.Command {
+	if len(stmts) == 1 {
+		return raft.Command{SQL: stmts[0].SQL, Params: stmts[0].Params}
+	}
+	return raft.Command{Statements: stmts, ContinueOnError: continueOnError}
+}
+
+// queryStatements runs the statements of a JSON query one after the other
+// and returns their results in the same order. A failed statement gets an
+// error in its result; running out of time or connections fails them all.
+// With routeWrites a statement that is not read-only fails them all with
+// ErrNotReadOnly, so the caller can apply the request as a write instead.
+func queryStatements(ctx context.Context, exec *executer.Executer, stmts []sql.Statement, format executer.Format, routeWrites bool) ([]gin.H, error) {
+	results := make([]gin.H, len(stmts))
+	for i, stmt := range stmts {
+		result, err := exec.ExecQuery(ctx, stmt.SQL, sql.Args(stmt.Params)...)
+		switch {
+		case err == nil:
+			results[i] = gin.H{"status": true, "result": result.Encode(format)}
+		case errors.Is(err, executer.ErrNotReadOnly) && routeWrites,
+			errors.Is(err, executer.ErrBusy),
+			errors.Is(err, executer.ErrTimeout),
+			ctx.Err() != nil:
+			return nil, err
+		default:
+			results[i] = gin.H{"status": false, "error": err.Error()}
+		}
+	}
+	return results, nil
+}
+
+// checkAllWrites fails with errMixedStatements when one of stmts is
+// read-only. A statement that cannot be prepared yet, such as an insert
+// into a table created earlier in the array, counts as a write.
+func checkAllWrites(ctx context.Context, exec *executer.Executer, stmts []sql.Statement) error {
+	for i, stmt := range stmts {
+		readOnly, err := exec.ReadOnly(ctx, stmt.SQL)
+		switch {
+		case errors.Is(err, executer.ErrBusy), errors.Is(err, executer.ErrTimeout), ctx.Err() != nil:
+			return err
+		case err == nil && readOnly:
+			return fmt.Errorf("%w: statement %d reads", errMixedStatements, i)
+		}
+	}
+	return nil
+}
+
+// queryJSON answers a query with a JSON body. Every statement gets a result
+// in the array, the request fails when one of them did. Streaming and
+// pagination take a form query.
+func queryJSON(ctx context.Context, c *gin.Context, m *raft.DBManager, exec *executer.Executer, dbID string, format executer.Format, level raft.ReadLevel, writeTimeout time.Duration) {
+	if c.Query("stream") != "" || c.Query("cursor") != "" || c.Query("page_size") != "" {
+		c.JSON(400, gin.H{"status": false, "error": "stream and pagination take a form query"})
+		return
+	}
+	body, err := decodeJSONBody(c)
+	if err != nil {
+		c.JSON(400, gin.H{"status": false, "error": err.Error()})
+		return
+	}
+	stmts := body.statements()
+	routeWrites := c.Query("route_writes") == "true"
+	results, err := queryStatements(ctx, exec, stmts, format, routeWrites)
+	if errors.Is(err, executer.ErrNotReadOnly) && routeWrites {
+		// a write would drop the rows of the reads next to it
+		if err := checkAllWrites(ctx, exec, stmts); err != nil {
+			queryError(c, err)
+			return
+		}
+		// the leader runs the same check and applies the statements
+		if forwardToLeader(c, m, dbID) {
+			return
+		}
+		applyCommand(c, m, dbID, newCommand(stmts, body.ContinueOnError), writeTimeout)
+		return
+	}
+	if err != nil {
+		queryError(c, err)
+		return
+	}
+	for _, res := range results {
+		if res["status"] == false {
+			c.JSON(400, gin.H{"status": false, "error": res["error"], "level": level, "result": results})
+			return
+		}
+	}
+	c.JSON(201, gin.H{"status": true, "level": level, "result": results})
+}
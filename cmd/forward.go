@@ -0,0 +1,84 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"rflite/internal/raft"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// forwardedHeader marks a request proxied from another node, so a node
+// that lost leadership in the meantime answers instead of proxying again
+const forwardedHeader = "X-Rflite-Forwarded"
+
+// forwardToLeader hands a write, or a read that needs the leader, for dbID
+// to the leader of its Raft group. The request is proxied unless the
+// client asked for ?redirect=true, in which case it gets a 307 to the
+// leader's HTTP address. It returns false when this node is the leader and
+// should serve the request itself.
+func forwardToLeader(c *gin.Context, m *raft.DBManager, dbID string) bool {
+	leader, err := m.IsLeader(dbID)
+	if err != nil {
+		if errors.Is(err, raft.ErrDBNotFound) {
+			c.JSON(404, gin.H{"status": false, "error": "database not found"})
+		} else {
+			c.JSON(500, gin.H{"status": false, "error": err.Error()})
+		}
+		return true
+	}
+	if leader {
+		return false
+	}
+	if c.GetHeader(forwardedHeader) != "" {
+		c.JSON(503, errorResponse("error", raft.ErrNotLeader))
+		return true
+	}
+
+	addr, err := m.LeaderHTTPAddr(dbID)
+	if err != nil {
+		c.JSON(503, errorResponse("error", err))
+		return true
+	}
+	target := &url.URL{Scheme: "http", Host: addr}
+
+	if c.Query("redirect") == "true" {
+		location := *c.Request.URL
+		location.Scheme = target.Scheme
+		location.Host = target.Host
+		c.Redirect(http.StatusTemporaryRedirect, location.String())
+		return true
+	}
+
+	proxy := &httputil.ReverseProxy{
+		Rewrite: func(pr *httputil.ProxyRequest) {
+			pr.SetURL(target)
+			pr.SetXForwarded()
+			pr.Out.Header.Set(forwardedHeader, m.NodeID())
+		},
+		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+			log.Printf("forward to leader %s failed: %v", addr, err)
+			m.ForgetHTTPAddr(addr)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadGateway)
+			json.NewEncoder(w).Encode(gin.H{"status": false, "error": err.Error()})
+		},
+	}
+	proxy.ServeHTTP(c.Writer, c.Request)
+	return true
+}
+
+// restoreFormBody puts the parsed form of a request back into its body,
+// so a request whose form was already read can still be proxied
+func restoreFormBody(c *gin.Context) {
+	body := c.Request.PostForm.Encode()
+	c.Request.Body = io.NopCloser(strings.NewReader(body))
+	c.Request.ContentLength = int64(len(body))
+	c.Request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+}
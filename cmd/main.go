@@ -1,73 +1,463 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
 	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"rflite/config"
 	"rflite/internal/executer"
+	"rflite/internal/mysql"
+	"rflite/internal/raft"
 	"rflite/internal/setup"
-	"rflite/internal/store"
+	"rflite/internal/sql"
+	"rflite/pkg"
+	"syscall"
 	"time"
 
 	"github.com/gin-gonic/gin"
 )
 
 func main() {
+	configPath := flag.String("config", "config.yaml", "path to the node configuration")
+	flag.Parse()
+
+	cfg, err := config.LoadConfig(*configPath)
+	if os.IsNotExist(err) {
+		cfg, err = config.Default(), nil
+	}
+	if err != nil {
+		log.Fatalf("failed to load config: %v", err)
+	}
+
 	g := gin.Default()
-	r, err := setup.SetupLeader()
+	m, err := setup.SetupNode(cfg)
 	if err != nil {
-		log.Fatalf("failed to setup leader: %v", err)
+		log.Fatalf("failed to setup node: %v", err)
 	}
+	defer m.Shutdown()
+
+	readers := executer.NewRegistry(m.DBPath, executer.Options{
+		ReadConns:     cfg.ReadConns,
+		MaxQueued:     cfg.MaxQueuedReads,
+		StmtCacheSize: cfg.StmtCacheSize,
+	})
+	defer readers.Close()
+	cursors := executer.NewCursors(cfg.CursorTTL)
+	m.OnReset(func(dbID string) {
+		if err := readers.Remove(dbID); err != nil {
+			log.Printf("failed to close readers of DB %s: %v", dbID, err)
+		}
+		cursors.Remove(dbID)
+	})
+	m.OnSchemaChange(readers.InvalidateStatements)
+
 	g.POST("/connect", func(c *gin.Context) {
-		// add to raft for sync data
+		var req raft.JoinRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(400, gin.H{"status": false, "error": err.Error()})
+			return
+		}
+		if req.NodeID == "" || req.RaftAddr == "" {
+			c.JSON(400, gin.H{"status": false, "error": "node_id and raft_addr are required"})
+			return
+		}
+		result := m.Join(req.NodeID, req.RaftAddr)
+		ok := true
+		for _, res := range result {
+			ok = ok && res.Status
+		}
+		code := 200
+		if !ok {
+			code = 500
+		}
+		c.JSON(code, gin.H{"status": ok, "result": result})
 	})
 	g.GET("/status", func(c *gin.Context) {
-		list := store.NewStore().ListDatabases()
-		status := gin.H{
-			"state":  "master", // master or slave
-			"leader": r.Leader(),
+		list := m.Databases()
+		status := gin.H{}
+		for _, dbID := range append([]string{raft.CatalogID}, list...) {
+			r, err := m.Raft(dbID)
+			if err != nil {
+				continue
+			}
+			leaderAddr, leaderID := r.LeaderWithID()
+			dbStatus := gin.H{
+				"state":     r.State().String(),
+				"leader":    leaderAddr,
+				"leader_id": leaderID,
+			}
+			if stats, ok := readers.StmtCacheStats(dbID); ok {
+				dbStatus["stmt_cache"] = stats
+			}
+			status[dbID] = dbStatus
 		}
 		c.JSON(200, gin.H{"status": true, "result": gin.H{
 			"databases": list,
+			"node_id":   m.NodeID(),
+			"raft_addr": m.RaftAddr(),
 			"status":    status,
 		}})
 	})
 
+	g.POST("/db", func(c *gin.Context) {
+		if forwardToLeader(c, m, raft.CatalogID) {
+			return
+		}
+		var req struct {
+			Name string `json:"name"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(400, gin.H{"status": false, "error": err.Error()})
+			return
+		}
+		if err := m.CreateDatabase(req.Name); err != nil {
+			code := 500
+			switch {
+			case errors.Is(err, raft.ErrInvalidDBName):
+				code = 400
+			case errors.Is(err, raft.ErrDBExists):
+				code = 409
+			case errors.Is(err, raft.ErrNotLeader):
+				code = 503
+			}
+			c.JSON(code, errorResponse("error", err))
+			return
+		}
+		c.JSON(201, gin.H{"status": true, "result": gin.H{"name": req.Name}})
+	})
+
+	g.DELETE("/db/:name", func(c *gin.Context) {
+		if forwardToLeader(c, m, raft.CatalogID) {
+			return
+		}
+		name := c.Param("name")
+		if err := m.DropDatabase(name); err != nil {
+			code := 500
+			switch {
+			case errors.Is(err, raft.ErrDBNotFound):
+				code = 404
+			case errors.Is(err, raft.ErrNotLeader):
+				code = 503
+			}
+			c.JSON(code, errorResponse("error", err))
+			return
+		}
+		c.JSON(200, gin.H{"status": true})
+	})
+
 	g.POST("/db/:name/query", func(c *gin.Context) {
-		q := c.PostForm("q")
 		name := c.Param("name")
-		db := store.NewStore().DatabaseExists(name)
-		if !db {
+		if !m.HasDatabase(name) {
 			c.JSON(404, gin.H{"error": "database not found"})
 			return
 		}
-		exec := executer.NewExecuter("./db/" + name + ".db")
-		result, err := exec.ExecQuery(q)
+		level, err := raft.ParseReadLevel(c.Query("level"))
+		if err != nil {
+			c.JSON(400, gin.H{"status": false, "error": err.Error()})
+			return
+		}
+		format, err := executer.ParseFormat(c.Query("format"))
+		if err != nil {
+			c.JSON(400, gin.H{"status": false, "error": err.Error()})
+			return
+		}
+		ctx, cancel, err := requestContext(c, cfg.ReadTimeout)
+		if err != nil {
+			c.JSON(400, gin.H{"status": false, "error": err.Error()})
+			return
+		}
+		defer cancel()
+		// weak and strong reads are served by the leader
+		if level != raft.LevelNone && forwardToLeader(c, m, name) {
+			return
+		}
+		if err := m.VerifyRead(name, level); err != nil {
+			code := 500
+			if errors.Is(err, raft.ErrNotLeader) {
+				code = 503
+			}
+			c.JSON(code, errorResponse("error", err))
+			return
+		}
+		exec, err := readers.Get(name)
+		if err != nil {
+			c.JSON(500, gin.H{"status": false, "error": err.Error()})
+			return
+		}
+		// the body is read only after forwarding, the proxy needs it
+		if isJSON(c) {
+			queryJSON(ctx, c, m, exec, name, format, level, cfg.WriteTimeout)
+			return
+		}
+		q := c.PostForm("q")
+		params, err := decodeParams(c.PostForm("params"))
+		if err != nil {
+			c.JSON(400, gin.H{"status": false, "error": err.Error()})
+			return
+		}
+		if c.Query("cursor") != "" || c.Query("page_size") != "" {
+			queryPage(ctx, c, exec, cursors, name, q, sql.Args(params), format, level)
+			return
+		}
+		if c.Query("stream") == "true" {
+			streamQuery(ctx, c, exec, q, sql.Args(params), format, level)
+			return
+		}
+		result, err := exec.ExecQuery(ctx, q, sql.Args(params)...)
+		if errors.Is(err, executer.ErrNotReadOnly) && c.Query("route_writes") == "true" {
+			// the leader runs the same check and applies it
+			restoreFormBody(c)
+			if forwardToLeader(c, m, name) {
+				return
+			}
+			applyCommand(c, m, name, raft.Command{SQL: q, Params: params}, cfg.WriteTimeout)
+			return
+		}
+		if err != nil {
+			queryError(c, err)
+			return
+		}
+		c.JSON(201, gin.H{"status": true, "level": level, "result": result.Encode(format)})
+	})
+
+	g.POST("/db/:name/explain", func(c *gin.Context) {
+		name := c.Param("name")
+		if !m.HasDatabase(name) {
+			c.JSON(404, gin.H{"status": false, "error": "database not found"})
+			return
+		}
+		ctx, cancel, err := requestContext(c, cfg.ReadTimeout)
+		if err != nil {
+			c.JSON(400, gin.H{"status": false, "error": err.Error()})
+			return
+		}
+		defer cancel()
+		params, err := decodeParams(c.PostForm("params"))
+		if err != nil {
+			c.JSON(400, gin.H{"status": false, "error": err.Error()})
+			return
+		}
+		exec, err := readers.Get(name)
 		if err != nil {
-			log.Printf("SQL Exec error: %v", err)
 			c.JSON(500, gin.H{"status": false, "error": err.Error()})
 			return
 		}
-		fmt.Println(len(result))
-		c.JSON(201, gin.H{"status": true, "result": result})
+		plan, err := exec.Explain(ctx, c.PostForm("q"), sql.Args(params)...)
+		if err != nil {
+			queryError(c, err)
+			return
+		}
+		c.JSON(200, gin.H{"status": true, "result": plan})
 	})
 
 	g.POST("/db/:name/exec", func(c *gin.Context) {
-		q := c.PostForm("q")
 		name := c.Param("name")
-		tt := "master"
-		if tt != "master" {
-			c.JSON(403, gin.H{"status": false, "message": "only master can exec queries"})
+		if forwardToLeader(c, m, name) {
 			return
 		}
-		query := []byte(fmt.Sprintf("USE %s; %s", name, q))
-		if err := r.Apply(query, 5*time.Second).Error(); err != nil {
-			c.JSON(500, gin.H{"status": false, "message": err.Error()})
+		cmd, err := requestCommand(c)
+		if err != nil {
+			c.JSON(400, gin.H{"status": false, "message": err.Error()})
 			return
 		}
-		c.JSON(201, gin.H{"status": true})
+		applyCommand(c, m, name, cmd, cfg.WriteTimeout)
+	})
+
+	g.POST("/sql", func(c *gin.Context) {
+		runScript(c, m, cfg.WriteTimeout)
 	})
 
-	if err := g.Run(":8001"); err != nil {
-		log.Fatalf("failed to run server: %v", err)
+	if cfg.MySQLAddr != "" {
+		l, err := net.Listen("tcp", cfg.MySQLAddr)
+		if err != nil {
+			log.Fatalf("failed to listen for MySQL clients: %v", err)
+		}
+		mysqlSrv := mysql.NewServer(m, readers, mysql.Options{
+			User:         cfg.MySQLUser,
+			Password:     cfg.MySQLPassword,
+			ReadTimeout:  cfg.ReadTimeout,
+			WriteTimeout: cfg.WriteTimeout,
+		})
+		defer mysqlSrv.Close()
+		go func() {
+			if err := mysqlSrv.Serve(l); err != nil {
+				log.Fatalf("failed to serve MySQL clients: %v", err)
+			}
+		}()
+	}
+
+	srv := &http.Server{Addr: fmt.Sprintf(":%d", cfg.Port), Handler: g}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Fatalf("failed to run server: %v", err)
+		}
+	}()
+
+	// on a signal, let running requests finish before the deferred
+	// cleanup closes the read pools and the Raft groups
+	<-ctx.Done()
+	log.Printf("shutting down")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		log.Printf("failed to shut down server: %v", err)
+	}
+}
+
+// formCommand builds the command of an exec request. A single "q" field
+// is one statement; repeating "q" runs all of them in one transaction,
+// with the n-th "params" field bound to the n-th statement.
+func formCommand(c *gin.Context) (raft.Command, error) {
+	qs := c.PostFormArray("q")
+	rawParams := c.PostFormArray("params")
+	if len(rawParams) > len(qs) {
+		return raft.Command{}, fmt.Errorf("got %d params for %d statements", len(rawParams), len(qs))
+	}
+
+	stmts := make([]sql.Statement, len(qs))
+	for i, q := range qs {
+		stmts[i].SQL = q
+		if i < len(rawParams) {
+			params, err := decodeParams(rawParams[i])
+			if err != nil {
+				return raft.Command{}, err
+			}
+			stmts[i].Params = params
+		}
+	}
+	return newCommand(stmts, c.PostForm("continue_on_error") == "true"), nil
+}
+
+// applyCommand replicates cmd through the Raft group of dbID and answers
+// with its result
+func applyCommand(c *gin.Context, m *raft.DBManager, dbID string, cmd raft.Command, timeout time.Duration) {
+	ctx, cancel, err := requestContext(c, timeout)
+	if err != nil {
+		c.JSON(400, gin.H{"status": false, "message": err.Error()})
+		return
+	}
+	defer cancel()
+	result, err := m.ApplyCommandContext(ctx, dbID, cmd)
+	if err != nil {
+		c.JSON(applyErrorCode(err), errorResponse("message", err))
+		return
+	}
+	if !result.Committed {
+		c.JSON(400, gin.H{"status": false, "message": result.Err().Error(), "result": result})
+		return
+	}
+	c.JSON(201, gin.H{"status": true, "result": result})
+}
+
+// applyErrorCode is the status of a write that could not be applied
+func applyErrorCode(err error) int {
+	var syntaxErr *pkg.SyntaxError
+	switch {
+	case errors.Is(err, raft.ErrNotLeader):
+		return 503
+	case errors.Is(err, raft.ErrApplyTimeout):
+		return 504
+	case errors.Is(err, raft.ErrLeadershipLost):
+		// the write may be committed, retrying could apply it twice
+		return 500
+	case errors.Is(err, sql.ErrNonDeterministic), errors.As(err, &syntaxErr):
+		return 400
+	}
+	return 500
+}
+
+// Codes of the "code" field of an error response, for the errors a client
+// acts on rather than shows
+const (
+	// codeNotLeader is a request this node refused because it does not
+	// lead the database; a write was not proposed and may be sent again
+	codeNotLeader = "not_leader"
+	// codeLeadershipLost is a write proposed by a leader that lost its
+	// leadership before it committed; it may or may not be applied
+	codeLeadershipLost = "leadership_lost"
+)
+
+// errorCode returns the code of err, empty when it has none
+func errorCode(err error) string {
+	switch {
+	case errors.Is(err, raft.ErrNotLeader), errors.Is(err, raft.ErrNoLeader):
+		return codeNotLeader
+	case errors.Is(err, raft.ErrLeadershipLost):
+		return codeLeadershipLost
+	}
+	return ""
+}
+
+// errorResponse is the body of a failed request, with err's message under
+// key and its code, when it has one
+func errorResponse(key string, err error) gin.H {
+	body := gin.H{"status": false, key: err.Error()}
+	if code := errorCode(err); code != "" {
+		body["code"] = code
+	}
+	return body
+}
+
+// requestContext returns the context of a request bounded by its
+// "timeout" query parameter, a duration such as 500ms or 2s, or by def
+// when there is none. A client that disconnects cancels it.
+func requestContext(c *gin.Context, def time.Duration) (context.Context, context.CancelFunc, error) {
+	timeout := def
+	if raw := c.Query("timeout"); raw != "" {
+		d, err := time.ParseDuration(raw)
+		if err != nil || d <= 0 {
+			return nil, nil, fmt.Errorf("invalid timeout %q", raw)
+		}
+		timeout = d
+	}
+	if timeout <= 0 {
+		ctx, cancel := context.WithCancel(c.Request.Context())
+		return ctx, cancel, nil
+	}
+	ctx, cancel := context.WithTimeout(c.Request.Context(), timeout)
+	return ctx, cancel, nil
+}
+
+// queryError answers a failed read, with 504 for a query that timed out
+func queryError(c *gin.Context, err error) {
+	if c.Request.Context().Err() != nil {
+		// the client is gone, nobody reads the error
+		return
+	}
+	code := 500
+	switch {
+	case errors.Is(err, executer.ErrNotPageable), errors.Is(err, executer.ErrMultipleStatements),
+		errors.Is(err, errMixedStatements):
+		code = 400
+	case errors.Is(err, executer.ErrNotReadOnly):
+		code = 403
+	case errors.Is(err, executer.ErrBusy):
+		code = 503
+	case errors.Is(err, executer.ErrTimeout):
+		code = 504
+	}
+	log.Printf("SQL Exec error: %v", err)
+	c.JSON(code, gin.H{"status": false, "error": err.Error()})
+}
+
+// decodeParams decodes a "params" form field, a JSON array of positional
+// values or {"name", "type", "value"} objects
+func decodeParams(raw string) ([]sql.Param, error) {
+	if raw == "" {
+		return nil, nil
+	}
+	var params []sql.Param
+	if err := json.Unmarshal([]byte(raw), &params); err != nil {
+		return nil, fmt.Errorf("invalid params: %w", err)
 	}
+	return params, nil
 }
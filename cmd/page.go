@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"rflite/internal/executer"
+	"rflite/internal/raft"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// queryPage serves one page of a paginated query. The first request sends
+// the query with a page_size, every following one only the cursor returned
+// with the previous page. The last page comes without a cursor.
+func queryPage(ctx context.Context, c *gin.Context, exec *executer.Executer, cursors *executer.Cursors, dbID, q string, args []interface{}, format executer.Format, level raft.ReadLevel) {
+	if c.Query("stream") == "true" {
+		c.JSON(400, gin.H{"status": false, "error": "pagination cannot be combined with stream"})
+		return
+	}
+
+	var cur *executer.Cursor
+	if token := c.Query("cursor"); token != "" {
+		var err error
+		cur, err = cursors.Get(token)
+		if err == nil && cur.DB != dbID {
+			err = executer.ErrCursorNotFound
+		}
+		if err != nil {
+			c.JSON(404, gin.H{"status": false, "error": err.Error()})
+			return
+		}
+	} else {
+		size, err := strconv.Atoi(c.Query("page_size"))
+		if err != nil || size <= 0 {
+			c.JSON(400, gin.H{"status": false, "error": "page_size must be a positive integer"})
+			return
+		}
+		cur = &executer.Cursor{DB: dbID, SQL: q, Args: args, PageSize: size}
+	}
+
+	result, next, err := exec.QueryPage(ctx, cur)
+	if err != nil {
+		queryError(c, err)
+		return
+	}
+	resp := gin.H{"status": true, "level": level, "result": result.Encode(format)}
+	if next != nil {
+		token, err := cursors.Put(next)
+		if err != nil {
+			c.JSON(500, gin.H{"status": false, "error": err.Error()})
+			return
+		}
+		resp["cursor"] = token
+	}
+	c.JSON(201, resp)
+}
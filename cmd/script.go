@@ -0,0 +1,159 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"rflite/internal/raft"
+	"rflite/internal/sql"
+	"rflite/pkg"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// runScript answers a POST /sql. The "q" field is a script of USE
+// statements, each followed by the statements for that database. Every
+// segment is applied in one transaction on the Raft group of its
+// database, in script order, and the result has one entry per segment in
+// that order. Segments run on different groups, so a failed segment stops
+// the script but does not undo the segments before it.
+func runScript(c *gin.Context, m *raft.DBManager, timeout time.Duration) {
+	blocks, err := pkg.ParseUseQuery(c.PostForm("q"))
+	if err != nil {
+		c.JSON(400, gin.H{"status": false, "message": err.Error()})
+		return
+	}
+	for _, block := range blocks {
+		if !m.HasDatabase(block.DB) {
+			c.JSON(404, gin.H{"status": false, "message": fmt.Sprintf("database %s not found", block.DB)})
+			return
+		}
+	}
+
+	continueOnError := c.PostForm("continue_on_error") == "true"
+	results := make([]segmentResult, 0, len(blocks))
+	for _, block := range blocks {
+		if len(block.Queries) == 0 {
+			continue
+		}
+		stmts := make([]sql.Statement, len(block.Queries))
+		for i, q := range block.Queries {
+			stmts[i].SQL = q
+		}
+		result, code, err := applySegment(c, m, block.DB, stmts, continueOnError, timeout)
+		if result != nil {
+			results = append(results, segmentResult{DB: block.DB, Result: result})
+		}
+		if err == nil && !result.Committed {
+			code, err = 400, result.Err()
+		}
+		if err != nil {
+			body := errorResponse("message", err)
+			body["db"], body["result"] = block.DB, results
+			c.JSON(code, body)
+			return
+		}
+	}
+	c.JSON(201, gin.H{"status": true, "result": results})
+}
+
+// segmentResult is the result of one segment of a script
+type segmentResult struct {
+	DB     string           `json:"db"`
+	Result *sql.ApplyResult `json:"result"`
+}
+
+// applySegment applies the statements of one database, here when this
+// node leads its group and through the leader's /exec otherwise. It
+// returns the status code to answer with when it fails.
+func applySegment(c *gin.Context, m *raft.DBManager, dbID string, stmts []sql.Statement, continueOnError bool, timeout time.Duration) (*sql.ApplyResult, int, error) {
+	ctx, cancel, err := requestContext(c, timeout)
+	if err != nil {
+		return nil, 400, err
+	}
+	defer cancel()
+
+	leader, err := m.IsLeader(dbID)
+	if err != nil {
+		return nil, 500, err
+	}
+	if leader {
+		result, err := m.ApplyCommandContext(ctx, dbID, newCommand(stmts, continueOnError))
+		if err != nil {
+			return nil, applyErrorCode(err), err
+		}
+		return result, 0, nil
+	}
+	if c.GetHeader(forwardedHeader) != "" {
+		return nil, 503, raft.ErrNotLeader
+	}
+	addr, err := m.LeaderHTTPAddr(dbID)
+	if err != nil {
+		return nil, 503, err
+	}
+	return execOnLeader(ctx, c, m, addr, dbID, stmts, continueOnError)
+}
+
+// execOnLeader sends the statements of dbID to the /exec endpoint of its
+// leader as a JSON body
+func execOnLeader(ctx context.Context, c *gin.Context, m *raft.DBManager, addr, dbID string, stmts []sql.Statement, continueOnError bool) (*sql.ApplyResult, int, error) {
+	body := jsonBody{
+		Statements:      make([]jsonStatement, len(stmts)),
+		ContinueOnError: continueOnError,
+	}
+	for i, stmt := range stmts {
+		body.Statements[i] = jsonStatement{Q: stmt.SQL, Params: stmt.Params}
+	}
+	data, err := json.Marshal(body)
+	if err != nil {
+		return nil, 500, err
+	}
+
+	target := url.URL{Scheme: "http", Host: addr, Path: "/db/" + dbID + "/exec"}
+	if timeout := c.Query("timeout"); timeout != "" {
+		target.RawQuery = url.Values{"timeout": {timeout}}.Encode()
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, target.String(), bytes.NewReader(data))
+	if err != nil {
+		return nil, 500, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(forwardedHeader, m.NodeID())
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		log.Printf("forward to leader %s failed: %v", addr, err)
+		m.ForgetHTTPAddr(addr)
+		return nil, http.StatusBadGateway, err
+	}
+	defer resp.Body.Close()
+
+	var out struct {
+		Status  bool             `json:"status"`
+		Result  *sql.ApplyResult `json:"result"`
+		Message string           `json:"message"`
+		Code    string           `json:"code"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return nil, http.StatusBadGateway, fmt.Errorf("leader %s: %w", addr, err)
+	}
+	if out.Result == nil {
+		if out.Message == "" {
+			out.Message = resp.Status
+		}
+		// keep the leader's code for the answer to the script
+		switch out.Code {
+		case codeNotLeader:
+			return nil, resp.StatusCode, fmt.Errorf("leader %s: %w", addr, raft.ErrNotLeader)
+		case codeLeadershipLost:
+			return nil, resp.StatusCode, fmt.Errorf("leader %s: %w", addr, raft.ErrLeadershipLost)
+		}
+		return nil, resp.StatusCode, errors.New(out.Message)
+	}
+	return out.Result, 0, nil
+}
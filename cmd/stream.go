@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"rflite/internal/executer"
+	"rflite/internal/raft"
+
+	"github.com/gin-gonic/gin"
+)
+
+// streamFlushRows is the number of rows written between two flushes
+const streamFlushRows = 100
+
+// streamQuery writes the result of a query as NDJSON while it is read: a
+// line with the columns and types, one line per row, an object in the rows
+// format or an array in the columnar format, and a last line with the
+// status. Rows are scanned only as fast as the client reads them, and a
+// client that disconnects cancels the query.
+func streamQuery(ctx context.Context, c *gin.Context, exec *executer.Executer, q string, args []interface{}, format executer.Format, level raft.ReadLevel) {
+	stream, err := exec.Stream(ctx, q, args...)
+	if err != nil {
+		queryError(c, err)
+		return
+	}
+	defer stream.Close()
+
+	c.Header("Content-Type", "application/x-ndjson")
+	c.Status(201)
+	enc := json.NewEncoder(c.Writer)
+	if err := enc.Encode(gin.H{"columns": stream.Columns, "types": stream.Types}); err != nil {
+		return
+	}
+
+	count := 0
+	var row map[string]interface{}
+	for stream.Next() {
+		var line interface{} = stream.Values()
+		if format == executer.FormatRows {
+			if row == nil {
+				row = make(map[string]interface{}, len(stream.Columns))
+			}
+			for i, col := range stream.Columns {
+				row[col] = stream.Values()[i]
+			}
+			line = row
+		}
+		// a write blocks while the client is not reading
+		if err := enc.Encode(line); err != nil {
+			return
+		}
+		count++
+		if count%streamFlushRows == 0 {
+			c.Writer.Flush()
+		}
+	}
+	if err := stream.Err(); err != nil {
+		if c.Request.Context().Err() != nil {
+			// the client is gone, nobody reads the error
+			return
+		}
+		log.Printf("SQL Exec error: %v", err)
+		enc.Encode(gin.H{"status": false, "error": err.Error(), "count": count})
+		return
+	}
+	enc.Encode(gin.H{"status": true, "level": level, "count": count})
+}
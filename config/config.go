@@ -2,6 +2,7 @@ package config
 
 import (
 	"os"
+	"time"
 
 	"gopkg.in/yaml.v2"
 )
@@ -10,6 +11,55 @@ type Config struct {
 	Name string `yaml:"name"`
 	Port int    `yaml:"port"`
 	Type string `yaml:"type"`
+	// RaftAddr is the address the multiplexed Raft listener binds to
+	RaftAddr string `yaml:"raft_addr"`
+	// AdvertiseAddr is the Raft address other nodes dial, if it differs
+	// from RaftAddr
+	AdvertiseAddr string   `yaml:"advertise_addr"`
+	DataDir       string   `yaml:"data_dir"`
+	Databases     []string `yaml:"databases"`
+	// HTTPAddr is the address other nodes use to reach this node's HTTP
+	// API. Defaults to 127.0.0.1:<port>.
+	HTTPAddr string `yaml:"http_addr"`
+	// Join is the HTTP address of any cluster member, used by a node
+	// that is not the master to join the cluster on startup
+	Join string `yaml:"join"`
+	// ReadConns is the number of read connections kept open per database
+	ReadConns int `yaml:"read_conns"`
+	// MaxQueuedReads is the number of queries per database that may wait
+	// for a read connection before new ones are rejected
+	MaxQueuedReads int `yaml:"max_queued_reads"`
+	// StmtCacheSize is the number of prepared statements kept per read
+	// connection, -1 disables the cache
+	StmtCacheSize int `yaml:"stmt_cache_size"`
+	// CursorTTL is how long the cursor of a paginated query stays valid
+	CursorTTL time.Duration `yaml:"cursor_ttl"`
+	// ReadTimeout bounds queries that do not set their own timeout, 0
+	// leaves them unbounded
+	ReadTimeout time.Duration `yaml:"read_timeout"`
+	// WriteTimeout bounds the wait for a write to be applied when the
+	// request does not set its own timeout
+	WriteTimeout time.Duration `yaml:"write_timeout"`
+	// MySQLAddr is the address of the MySQL protocol listener, empty
+	// disables it
+	MySQLAddr string `yaml:"mysql_addr"`
+	// MySQLUser and MySQLPassword are the credentials of MySQL clients,
+	// without a user every client is accepted
+	MySQLUser     string `yaml:"mysql_user"`
+	MySQLPassword string `yaml:"mysql_password"`
+}
+
+// Default returns the configuration of a single master node
+func Default() *Config {
+	return &Config{
+		Port:         8001,
+		Type:         "master",
+		RaftAddr:     "127.0.0.1:7001",
+		DataDir:      "./db",
+		CursorTTL:    5 * time.Minute,
+		ReadTimeout:  30 * time.Second,
+		WriteTimeout: 5 * time.Second,
+	}
 }
 
 func LoadConfig(path string) (*Config, error) {
@@ -18,10 +68,10 @@ func LoadConfig(path string) (*Config, error) {
 		return nil, err
 	}
 
-	var cfg Config
-	if err := yaml.Unmarshal(data, &cfg); err != nil {
+	cfg := Default()
+	if err := yaml.Unmarshal(data, cfg); err != nil {
 		return nil, err
 	}
 
-	return &cfg, nil
+	return cfg, nil
 }
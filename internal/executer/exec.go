@@ -1,121 +1,243 @@
 package executer
 
 import (
+	"context"
 	"database/sql"
-	"log"
+	"errors"
+	"fmt"
 	"sync"
+	"sync/atomic"
 
 	"github.com/jacob2161/sqlitebp"
 )
 
-var rowMapPool = sync.Pool{
-	New: func() interface{} {
-		return make(map[string]interface{})
-	},
+var (
+	// ErrBusy is returned when a query would have to queue behind more
+	// queries than Options.MaxQueued allows
+	ErrBusy = errors.New("too many concurrent queries")
+	// ErrTimeout is returned when a query runs past its deadline
+	ErrTimeout = errors.New("query timed out")
+)
+
+// queryErr reports the error of a query interrupted by the deadline of
+// ctx as ErrTimeout
+func queryErr(ctx context.Context, err error) error {
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return fmt.Errorf("%w: %v", ErrTimeout, err)
+	}
+	return err
+}
+
+// Options bounds the read connections of an Executer
+type Options struct {
+	// ReadConns is the number of read connections, and so the number of
+	// queries running at once. Defaults to 4.
+	ReadConns int
+	// MaxQueued is the number of queries that may wait for a connection,
+	// any further query fails with ErrBusy. Defaults to 64.
+	MaxQueued int
+	// StmtCacheSize is the number of prepared statements each read
+	// connection keeps, keyed by SQL text. Defaults to 64, a negative
+	// value disables the cache.
+	StmtCacheSize int
+}
+
+func (o Options) withDefaults() Options {
+	if o.ReadConns <= 0 {
+		o.ReadConns = 4
+	}
+	if o.MaxQueued <= 0 {
+		o.MaxQueued = 64
+	}
+	if o.StmtCacheSize == 0 {
+		o.StmtCacheSize = 64
+	}
+	return o
 }
 
+// Executer runs reads against one database over a long-lived pool of
+// read-only connections
 type Executer struct {
 	db   string
 	read *sql.DB
+	// slots holds a token for every running or queued query
+	slots chan struct{}
+
+	stmtCacheSize int
+	// schema is bumped by InvalidateStatements
+	schema       atomic.Uint64
+	hits, misses atomic.Uint64
+
+	mu     sync.Mutex
+	idle   []*readConn
+	closed bool
 }
 
-func NewExecuter(name string) *Executer {
+// NewExecuter opens the read pool of the database file name
+func NewExecuter(name string, opts Options) (*Executer, error) {
+	opts = opts.withDefaults()
 	db, err := sqlitebp.OpenReadOnly(name)
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
-	// return db, nil
+	db.SetMaxOpenConns(opts.ReadConns)
+	db.SetMaxIdleConns(opts.ReadConns)
 	return &Executer{
-		db:   name,
-		read: db,
-	}
+		db:            name,
+		read:          db,
+		slots:         make(chan struct{}, opts.ReadConns+opts.MaxQueued),
+		stmtCacheSize: opts.StmtCacheSize,
+	}, nil
 }
 
 func (e *Executer) Exec(sql []string) error {
-	open, err := e.open(e.db)
+	db, err := sqlitebp.OpenReadWriteCreate(e.db)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	tx, err := db.Begin()
 	if err != nil {
 		return err
 	}
 	for _, sql := range sql {
-		_, err = open.Exec(sql)
+		_, err = tx.Exec(sql)
 		if err != nil {
+			tx.Rollback()
 			return err
 		}
 	}
-	return nil
+	return tx.Commit()
 }
 
-func (e *Executer) ExecQuery(sqlStr string) ([]map[string]interface{}, error) {
-	// e.openReadOnly(e.db)
-	db, err := sqlitebp.OpenReadOnly(e.db)
+// ExecQuery runs a query and returns its whole result. When ctx is done
+// the driver interrupts the query with sqlite3_interrupt; a deadline
+// fails it with ErrTimeout.
+func (e *Executer) ExecQuery(ctx context.Context, sqlStr string, args ...interface{}) (*Result, error) {
+	rows, release, err := e.query(ctx, sqlStr, args)
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
-	defer db.Close()
-	rows, err := db.Query(sqlStr)
+	defer release()
+
+	columns, err := rows.Columns()
 	if err != nil {
 		return nil, err
 	}
-	defer rows.Close()
-	//
-	return e.RowsToMap(rows)
-}
-
-func (f *Executer) open(name string) (*sql.DB, error) {
-	db, err := sqlitebp.OpenReadWriteCreate(name)
+	types, err := columnTypes(rows)
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
+	}
+	values, err := e.RowsToValues(rows)
+	if err != nil {
+		return nil, queryErr(ctx, err)
 	}
-	f.read = db
-	return db, nil
+	return &Result{Columns: columns, Types: types, Values: values}, nil
 }
 
-func (f *Executer) openReadOnly(name string) (*sql.DB, error) {
-	db, err := sqlitebp.OpenReadOnly(name)
+// query starts a query on a read connection once every statement proved
+// read-only, reusing the connection's prepared statement when it has one.
+// release closes the rows and frees the connection.
+func (e *Executer) query(ctx context.Context, sqlStr string, args []interface{}) (*sql.Rows, func(), error) {
+	select {
+	case e.slots <- struct{}{}:
+	default:
+		return nil, nil, ErrBusy
+	}
+	rc, err := e.acquire(ctx)
+	if err != nil {
+		<-e.slots
+		return nil, nil, queryErr(ctx, err)
+	}
+	fail := func(err error) (*sql.Rows, func(), error) {
+		e.release(rc, err)
+		<-e.slots
+		return nil, nil, err
+	}
+	stmt, err := e.prepare(ctx, rc, sqlStr)
+	if err != nil {
+		return fail(err)
+	}
+	var rows *sql.Rows
+	if stmt != nil {
+		rows, err = stmt.QueryContext(ctx, args...)
+	} else {
+		rows, err = rc.conn.QueryContext(ctx, sqlStr, args...)
+	}
 	if err != nil {
-		log.Fatal(err)
+		return fail(queryErr(ctx, err))
+	}
+	release := func() {
+		rows.Close()
+		e.release(rc, rows.Err())
+		<-e.slots
 	}
-	return db, nil
+	return rows, release, nil
 }
 
-func (f *Executer) Close() error {
-	return f.read.Close()
+// Close closes the read connections. Connections still running a query
+// are closed once it is done.
+func (e *Executer) Close() error {
+	e.mu.Lock()
+	idle := e.idle
+	e.idle = nil
+	e.closed = true
+	e.mu.Unlock()
+	for _, rc := range idle {
+		rc.close()
+	}
+	return e.read.Close()
 }
 
-func (e *Executer) RowsToMap(rows *sql.Rows) ([]map[string]interface{}, error) {
+// valuesBlockRows is the most rows allocated at once by RowsToValues
+const valuesBlockRows = 64
+
+// RowsToValues reads every row of rows as one slice of values in column
+// order
+func (e *Executer) RowsToValues(rows *sql.Rows) ([][]interface{}, error) {
 	columns, err := rows.Columns()
 	if err != nil {
 		return nil, err
 	}
 
-	row := make([]interface{}, len(columns))
-	for i := range row {
-		row[i] = new(sql.RawBytes)
+	dest := make([]interface{}, len(columns))
+	for i := range dest {
+		dest[i] = new(interface{})
 	}
 
-	var result []map[string]interface{}
+	var result [][]interface{}
+	// rows are carved out of larger blocks to keep allocations down on
+	// wide results
+	var block []interface{}
 
 	for rows.Next() {
-		if err := rows.Scan(row...); err != nil {
+		if err := rows.Scan(dest...); err != nil {
 			return nil, err
 		}
-		rowMap := make(map[string]interface{}, len(columns))
-		for i, colName := range columns {
-			if col, ok := row[i].(*sql.RawBytes); ok {
-				// RawBytes → string
-				rowMap[colName] = string(*col)
-			} else {
-				rowMap[colName] = nil
-			}
+		if len(block) < len(columns) {
+			// grow blocks with the result, small results stay small
+			n := min(max(len(result), 1), valuesBlockRows)
+			block = make([]interface{}, len(columns)*n)
 		}
-		result = append(result, rowMap)
+		row := block[:len(columns):len(columns)]
+		block = block[len(columns):]
+		for i := range row {
+			row[i] = value(*dest[i].(*interface{}))
+		}
+		result = append(result, row)
 	}
 
 	return result, rows.Err()
 }
 
-func ReturnMapsToPool(maps []map[string]interface{}) {
-	for _, m := range maps {
-		rowMapPool.Put(m)
+func (e *Executer) RowsToMap(rows *sql.Rows) ([]map[string]interface{}, error) {
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+	values, err := e.RowsToValues(rows)
+	if err != nil {
+		return nil, err
 	}
+	return toMaps(columns, values), nil
 }
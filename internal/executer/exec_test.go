@@ -1,6 +1,7 @@
 package executer
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 	"sync"
@@ -12,15 +13,20 @@ import (
 
 func BenchmarkSQLiteExecQueryConcurrent(b *testing.B) {
 	dbFile := "test_load.db"
-	exe := NewExecuter(dbFile)
-
+	concurrency := 100
 	setupSQL := []string{
 		`DROP TABLE IF EXISTS users;`,
 		`CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT);`,
 	}
-	if err := exe.Exec(setupSQL); err != nil {
+	// the read pool needs the file to exist
+	if err := (&Executer{db: dbFile}).Exec(setupSQL); err != nil {
 		b.Fatalf("failed to setup db: %v", err)
 	}
+	exe, err := NewExecuter(dbFile, Options{MaxQueued: concurrency})
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer exe.Close()
 
 	// insert data
 	insertSQL := []string{}
@@ -32,7 +38,6 @@ func BenchmarkSQLiteExecQueryConcurrent(b *testing.B) {
 	}
 	fmt.Println("Data inserted success.")
 
-	concurrency := 100
 	queriesPerWorker := 60
 
 	b.ResetTimer()
@@ -45,7 +50,7 @@ func BenchmarkSQLiteExecQueryConcurrent(b *testing.B) {
 			defer wg.Done()
 			for j := 0; j < queriesPerWorker; j++ {
 				sqlStr := fmt.Sprintf(`SELECT * FROM users WHERE id = %d;`, (workerID*queriesPerWorker+j)%1000)
-				_, err := exe.ExecQuery(sqlStr)
+				_, err := exe.ExecQuery(context.Background(), sqlStr)
 				if err != nil {
 					b.Errorf("worker %d failed: %v", workerID, err)
 				}
@@ -68,14 +73,22 @@ func BenchmarkSQLiteMultiDBExecQuery(b *testing.B) {
 
 	executers := make([]*Executer, len(dbs))
 	for i, db := range dbs {
-		executers[i] = NewExecuter(db)
+		if err := (&Executer{db: db}).Exec([]string{"SELECT 1"}); err != nil {
+			b.Fatal(err)
+		}
+		e, err := NewExecuter(db, Options{})
+		if err != nil {
+			b.Fatal(err)
+		}
+		defer e.Close()
+		executers[i] = e
 	}
 
 	b.ResetTimer()
 	b.RunParallel(func(pb *testing.PB) {
 		for pb.Next() {
 			for _, e := range executers {
-				if _, err := e.ExecQuery("SELECT 1"); err != nil {
+				if _, err := e.ExecQuery(context.Background(), "SELECT 1"); err != nil {
 					b.Fatal(err)
 				}
 			}
@@ -161,3 +174,23 @@ func BenchmarkRowsToMap(b *testing.B) {
 		rows.Close()
 	}
 }
+
+func BenchmarkRowsToValues(b *testing.B) {
+	db := setupTestDB(nil)
+	defer db.Close()
+
+	ex := &Executer{}
+
+	for i := 0; i < b.N; i++ {
+		rows, err := db.Query("SELECT id, name, age FROM users ORDER BY id")
+		if err != nil {
+			b.Fatal(err)
+		}
+
+		_, err = ex.RowsToValues(rows)
+		if err != nil {
+			b.Fatal(err)
+		}
+		rows.Close()
+	}
+}
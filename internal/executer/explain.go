@@ -0,0 +1,100 @@
+package executer
+
+import (
+	"context"
+	"strings"
+)
+
+// PlanNode is one step of a query plan as reported by EXPLAIN QUERY PLAN
+type PlanNode struct {
+	ID     int    `json:"id"`
+	Detail string `json:"detail"`
+	// FullScan marks a scan of a whole table, usually a missing index
+	FullScan bool `json:"full_scan,omitempty"`
+	// TempBTree marks a temporary B-tree built for ORDER BY, GROUP BY or
+	// DISTINCT that no index provides
+	TempBTree bool        `json:"temp_btree,omitempty"`
+	Children  []*PlanNode `json:"children,omitempty"`
+}
+
+// Plan is the query plan of a statement
+type Plan struct {
+	Nodes      []*PlanNode `json:"nodes"`
+	FullScans  int         `json:"full_scans"`
+	TempBTrees int         `json:"temp_btrees"`
+}
+
+// Explain returns the query plan of a single statement. The statement is
+// only planned, never run, so writes can be explained too.
+func (e *Executer) Explain(ctx context.Context, sqlStr string, args ...interface{}) (*Plan, error) {
+	stmt, err := trimStatement(sqlStr)
+	if err != nil {
+		return nil, err
+	}
+	select {
+	case e.slots <- struct{}{}:
+		defer func() { <-e.slots }()
+	default:
+		return nil, ErrBusy
+	}
+	rc, err := e.acquire(ctx)
+	if err != nil {
+		return nil, queryErr(ctx, err)
+	}
+
+	rows, err := rc.conn.QueryContext(ctx, "EXPLAIN QUERY PLAN "+stmt, args...)
+	if err != nil {
+		e.release(rc, err)
+		return nil, queryErr(ctx, err)
+	}
+	defer func() {
+		rows.Close()
+		e.release(rc, rows.Err())
+	}()
+
+	plan := &Plan{}
+	nodes := make(map[int]*PlanNode)
+	for rows.Next() {
+		var id, parent, notUsed int
+		var detail string
+		if err := rows.Scan(&id, &parent, &notUsed, &detail); err != nil {
+			return nil, err
+		}
+		node := &PlanNode{
+			ID:        id,
+			Detail:    detail,
+			FullScan:  isFullScan(detail),
+			TempBTree: strings.Contains(detail, "TEMP B-TREE"),
+		}
+		if node.FullScan {
+			plan.FullScans++
+		}
+		if node.TempBTree {
+			plan.TempBTrees++
+		}
+		nodes[id] = node
+		if p, ok := nodes[parent]; ok {
+			p.Children = append(p.Children, node)
+		} else {
+			plan.Nodes = append(plan.Nodes, node)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, queryErr(ctx, err)
+	}
+	return plan, nil
+}
+
+// isFullScan reports whether a plan detail scans a table without an
+// index: "SCAN t" but not "SCAN t USING INDEX i", "SCAN CONSTANT ROW" or
+// a virtual table
+func isFullScan(detail string) bool {
+	if !strings.HasPrefix(detail, "SCAN ") {
+		return false
+	}
+	rest := strings.TrimPrefix(detail, "SCAN ")
+	if strings.HasPrefix(rest, "CONSTANT ROW") || strings.Contains(rest, "VIRTUAL TABLE") {
+		return false
+	}
+	return !strings.Contains(rest, " USING ")
+}
@@ -0,0 +1,64 @@
+package executer
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+func TestExplain(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "explain.db")
+	if err := (&Executer{db: path}).Exec([]string{
+		"CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT, city TEXT)",
+		"CREATE INDEX users_name ON users (name)",
+	}); err != nil {
+		t.Fatal(err)
+	}
+	e, err := NewExecuter(path, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer e.Close()
+	ctx := context.Background()
+
+	plan, err := e.Explain(ctx, "SELECT city, count(*) FROM users WHERE city <> ? GROUP BY city;", "")
+	if err != nil {
+		t.Fatalf("Explain failed: %v", err)
+	}
+	if plan.FullScans != 1 || plan.TempBTrees != 1 || len(plan.Nodes) != 2 {
+		t.Fatalf("unexpected plan %+v", plan)
+	}
+	if !plan.Nodes[0].FullScan || !plan.Nodes[1].TempBTree {
+		t.Fatalf("unexpected nodes %+v %+v", plan.Nodes[0], plan.Nodes[1])
+	}
+
+	plan, err = e.Explain(ctx, "SELECT id FROM users WHERE name = 'a'")
+	if err != nil {
+		t.Fatalf("Explain failed: %v", err)
+	}
+	if plan.FullScans != 0 || plan.TempBTrees != 0 {
+		t.Fatalf("indexed lookup flagged: %+v", plan.Nodes[0])
+	}
+
+	// subqueries nest under their parent step
+	plan, err = e.Explain(ctx, "SELECT * FROM users WHERE id IN (SELECT id FROM users WHERE name = 'a')")
+	if err != nil {
+		t.Fatalf("Explain failed: %v", err)
+	}
+	nested := false
+	for _, n := range plan.Nodes {
+		nested = nested || len(n.Children) > 0
+	}
+	if !nested {
+		t.Fatalf("expected a nested plan, got %+v", plan.Nodes)
+	}
+
+	// writes are planned, not run
+	if _, err := e.Explain(ctx, "DELETE FROM users WHERE city = 'x'"); err != nil {
+		t.Fatalf("Explain of a write failed: %v", err)
+	}
+	if _, err := e.Explain(ctx, "SELECT 1; DROP TABLE users"); !errors.Is(err, ErrMultipleStatements) {
+		t.Fatalf("got %v, want ErrMultipleStatements", err)
+	}
+}
@@ -0,0 +1,368 @@
+package executer
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"rflite/pkg"
+)
+
+var (
+	ErrCursorNotFound = errors.New("cursor not found or expired")
+	ErrNotPageable    = errors.New("query cannot be paginated")
+	// ErrMultipleStatements is returned where only one statement is
+	// allowed
+	ErrMultipleStatements = errors.New("more than one statement")
+)
+
+// maxCursors bounds the cursors kept by a Cursors store
+const maxCursors = 10000
+
+// Cursor is where a paginated query resumes
+type Cursor struct {
+	DB       string
+	SQL      string
+	Args     []interface{}
+	PageSize int
+	// Keyset cursors resume after the rowid of the last row, the others
+	// skip Offset rows
+	Keyset     bool
+	AfterRowID int64
+	Offset     int
+
+	expires time.Time
+}
+
+// Cursors keeps the cursors handed to clients until they expire
+type Cursors struct {
+	ttl time.Duration
+
+	mu        sync.Mutex
+	cursors   map[string]*Cursor
+	lastSweep time.Time
+}
+
+// NewCursors creates a store whose cursors expire ttl after they were
+// handed out
+func NewCursors(ttl time.Duration) *Cursors {
+	return &Cursors{ttl: ttl, cursors: make(map[string]*Cursor)}
+}
+
+// Put stores cur and returns its token
+func (s *Cursors) Put(cur *Cursor) (string, error) {
+	var buf [18]byte
+	if _, err := rand.Read(buf[:]); err != nil {
+		return "", err
+	}
+	token := base64.RawURLEncoding.EncodeToString(buf[:])
+
+	now := time.Now()
+	cur.expires = now.Add(s.ttl)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.cursors) >= maxCursors || now.Sub(s.lastSweep) > s.ttl {
+		s.sweep(now)
+	}
+	s.cursors[token] = cur
+	return token, nil
+}
+
+// sweep removes expired cursors, and the one closest to expiring when the
+// store is still full
+func (s *Cursors) sweep(now time.Time) {
+	s.lastSweep = now
+	var oldest string
+	for token, cur := range s.cursors {
+		if now.After(cur.expires) {
+			delete(s.cursors, token)
+			continue
+		}
+		if oldest == "" || cur.expires.Before(s.cursors[oldest].expires) {
+			oldest = token
+		}
+	}
+	if len(s.cursors) >= maxCursors {
+		delete(s.cursors, oldest)
+	}
+}
+
+// Get returns the cursor of token. A cursor stays valid until it expires,
+// so a client can fetch the same page again after a failure.
+func (s *Cursors) Get(token string) (*Cursor, error) {
+	s.mu.Lock()
+	cur, ok := s.cursors[token]
+	s.mu.Unlock()
+	if !ok || time.Now().After(cur.expires) {
+		return nil, ErrCursorNotFound
+	}
+	return cur, nil
+}
+
+// Remove drops the cursors of a database
+func (s *Cursors) Remove(dbID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for token, cur := range s.cursors {
+		if cur.DB == dbID {
+			delete(s.cursors, token)
+		}
+	}
+}
+
+// QueryPage returns the page of cur's query starting at its position, and
+// the cursor of the next page, nil after the last one. A new cursor
+// starts with keyset pagination on the rowid when the query is a plain
+// SELECT on one table, and falls back to LIMIT and OFFSET otherwise.
+func (e *Executer) QueryPage(ctx context.Context, cur *Cursor) (*Result, *Cursor, error) {
+	if cur.PageSize <= 0 {
+		return nil, nil, fmt.Errorf("%w: page size must be positive", ErrNotPageable)
+	}
+	stmt, err := trimStatement(cur.SQL)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: %w", ErrNotPageable, err)
+	}
+
+	fresh := !cur.Keyset && cur.Offset == 0
+	if cur.Keyset || fresh {
+		var after *int64
+		if cur.Keyset {
+			after = &cur.AfterRowID
+		}
+		if keyset, ok := keysetQuery(stmt, after, cur.PageSize+1); ok {
+			res, err := e.ExecQuery(ctx, keyset, cur.Args...)
+			if err == nil {
+				return keysetPage(res, cur)
+			}
+			// views and WITHOUT ROWID tables have no rowid
+			if cur.Keyset || ctx.Err() != nil {
+				return nil, nil, err
+			}
+		}
+	}
+
+	res, err := e.ExecQuery(ctx, offsetQuery(stmt, cur.Offset, cur.PageSize+1), cur.Args...)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(res.Values) <= cur.PageSize {
+		return res, nil, nil
+	}
+	res.Values = res.Values[:cur.PageSize]
+	next := *cur
+	next.Keyset = false
+	next.Offset = cur.Offset + cur.PageSize
+	return res, &next, nil
+}
+
+// keysetPage strips the rowid column keysetQuery added and moves the
+// cursor after the last row
+func keysetPage(res *Result, cur *Cursor) (*Result, *Cursor, error) {
+	n := len(res.Columns) - 1
+	more := len(res.Values) > cur.PageSize
+	if more {
+		res.Values = res.Values[:cur.PageSize]
+	}
+	var last int64
+	for i, row := range res.Values {
+		id, ok := row[n].(int64)
+		if !ok {
+			return nil, nil, fmt.Errorf("%w: rowid is not an integer", ErrNotPageable)
+		}
+		last = id
+		res.Values[i] = row[:n]
+	}
+	res.Columns = res.Columns[:n]
+	res.Types = res.Types[:n]
+	if !more {
+		return res, nil, nil
+	}
+	next := *cur
+	next.Keyset = true
+	next.AfterRowID = last
+	return res, &next, nil
+}
+
+// trimStatement returns the single statement of sqlStr without its
+// trailing semicolon
+func trimStatement(sqlStr string) (string, error) {
+	tokens, err := pkg.Tokenize(sqlStr)
+	if err != nil {
+		return "", err
+	}
+	end := 0
+	for i, t := range tokens {
+		switch t.Kind {
+		case pkg.TokenWhitespace, pkg.TokenComment:
+		case pkg.TokenSemicolon:
+			for _, rest := range tokens[i+1:] {
+				if rest.Kind != pkg.TokenWhitespace && rest.Kind != pkg.TokenComment && rest.Kind != pkg.TokenSemicolon {
+					return "", ErrMultipleStatements
+				}
+			}
+			return sqlStr[:end], nil
+		default:
+			end = t.Pos + len(t.Text)
+		}
+	}
+	return sqlStr[:end], nil
+}
+
+// aggregates are the functions that turn the rows of a query into one
+var aggregates = map[string]bool{
+	"avg": true, "count": true, "group_concat": true, "json_group_array": true,
+	"json_group_object": true, "max": true, "min": true, "string_agg": true,
+	"sum": true, "total": true,
+}
+
+// keysetQuery rewrites a SELECT of some columns from one table, with an
+// optional WHERE, to return the rows after rowid in rowid order with the
+// rowid as an extra last column; all of them when after is nil. It
+// reports false for any other query, aggregates included.
+func keysetQuery(stmt string, after *int64, limit int) (string, bool) {
+	tokens, err := pkg.Tokenize(stmt)
+	if err != nil {
+		return "", false
+	}
+	sig := topLevel(tokens)
+	if len(sig) < 4 || !sig[0].Is("SELECT") {
+		return "", false
+	}
+
+	from, where := -1, -1
+	for i, t := range sig {
+		for _, word := range []string{"DISTINCT", "GROUP", "HAVING", "ORDER", "LIMIT", "UNION", "INTERSECT", "EXCEPT", "JOIN", "WINDOW", "VALUES"} {
+			if t.Is(word) {
+				return "", false
+			}
+		}
+		switch {
+		case t.Is("FROM") && from < 0:
+			from = i
+		case t.Is("WHERE") && where < 0:
+			where = i
+		}
+	}
+	if from < 2 || hasAggregate(tokens, sig[from].Pos) {
+		return "", false
+	}
+
+	// FROM table [[AS] alias], optionally schema qualified
+	end := where
+	if end < 0 {
+		end = len(sig)
+	}
+	source := sig[from+1 : end]
+	name := func(t pkg.Token) bool { return t.Kind == pkg.TokenIdent || t.Kind == pkg.TokenQuotedIdent }
+	if len(source) >= 3 && source[1].Kind == pkg.TokenPunct && source[1].Text == "." && name(source[0]) && name(source[2]) {
+		source = source[2:]
+	}
+	ref := ""
+	switch {
+	case len(source) == 1 && name(source[0]):
+		ref = source[0].Text
+	case len(source) == 2 && name(source[0]) && name(source[1]):
+		ref = source[1].Text
+	case len(source) == 3 && name(source[0]) && source[1].Is("AS") && name(source[2]):
+		ref = source[2].Text
+	default:
+		return "", false
+	}
+
+	rowid := ref + ".rowid"
+	fromPos := sig[from].Pos
+	var b strings.Builder
+	b.WriteString(strings.TrimRight(stmt[:fromPos], " \t\r\n"))
+	fmt.Fprintf(&b, ", %s ", rowid)
+	switch {
+	case after == nil:
+		b.WriteString(stmt[fromPos:])
+	case where < 0:
+		b.WriteString(stmt[fromPos:])
+		fmt.Fprintf(&b, " WHERE %s > %d", rowid, *after)
+	default:
+		wherePos := sig[where].Pos
+		b.WriteString(stmt[fromPos:wherePos])
+		fmt.Fprintf(&b, "WHERE (%s\n) AND %s > %d", stmt[wherePos+len("WHERE"):], rowid, *after)
+	}
+	fmt.Fprintf(&b, " ORDER BY %s LIMIT %d", rowid, limit)
+	return b.String(), true
+}
+
+// hasAggregate reports whether an aggregate function is called before
+// end outside of subqueries
+func hasAggregate(tokens []pkg.Token, end int) bool {
+	var sig []pkg.Token
+	for _, t := range tokens {
+		if t.Pos >= end {
+			break
+		}
+		if t.Kind != pkg.TokenWhitespace && t.Kind != pkg.TokenComment {
+			sig = append(sig, t)
+		}
+	}
+	// subquery holds, for every open parenthesis, whether it is inside a
+	// subquery
+	var subquery []bool
+	for i, t := range sig {
+		inSubquery := len(subquery) > 0 && subquery[len(subquery)-1]
+		switch {
+		case t.Kind == pkg.TokenPunct && t.Text == "(":
+			subquery = append(subquery, inSubquery || (i+1 < len(sig) && sig[i+1].Is("SELECT")))
+		case t.Kind == pkg.TokenPunct && t.Text == ")":
+			if len(subquery) > 0 {
+				subquery = subquery[:len(subquery)-1]
+			}
+		case t.Kind == pkg.TokenIdent && aggregates[strings.ToLower(t.Text)] && !inSubquery &&
+			i+1 < len(sig) && sig[i+1].Kind == pkg.TokenPunct && sig[i+1].Text == "(":
+			return true
+		}
+	}
+	return false
+}
+
+// topLevel returns the significant tokens outside parentheses, keeping
+// the outermost parentheses themselves
+func topLevel(tokens []pkg.Token) []pkg.Token {
+	var sig []pkg.Token
+	depth := 0
+	for _, t := range tokens {
+		if t.Kind == pkg.TokenWhitespace || t.Kind == pkg.TokenComment {
+			continue
+		}
+		open := t.Kind == pkg.TokenPunct && t.Text == "("
+		closing := t.Kind == pkg.TokenPunct && t.Text == ")"
+		if closing {
+			depth--
+		}
+		if depth == 0 {
+			sig = append(sig, t)
+		}
+		if open {
+			depth++
+		}
+	}
+	return sig
+}
+
+// offsetQuery limits stmt to limit rows after offset. The clause is
+// appended when stmt has no LIMIT of its own, which keeps its column
+// names; otherwise stmt becomes a subquery.
+func offsetQuery(stmt string, offset, limit int) string {
+	if tokens, err := pkg.Tokenize(stmt); err == nil {
+		sig := topLevel(tokens)
+		limited := false
+		for _, t := range sig {
+			limited = limited || t.Is("LIMIT")
+		}
+		if len(sig) > 0 && (sig[0].Is("SELECT") || sig[0].Is("WITH") || sig[0].Is("VALUES")) && !limited {
+			return fmt.Sprintf("%s\nLIMIT %d OFFSET %d", stmt, limit, offset)
+		}
+	}
+	return fmt.Sprintf("SELECT * FROM (%s\n) LIMIT %d OFFSET %d", stmt, limit, offset)
+}
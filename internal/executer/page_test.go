@@ -0,0 +1,166 @@
+package executer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestKeysetQuery(t *testing.T) {
+	tests := []struct {
+		sql   string
+		want  string
+		first bool
+	}{
+		{"SELECT * FROM t", "SELECT *, t.rowid FROM t WHERE t.rowid > 5 ORDER BY t.rowid LIMIT 3", false},
+		// the first page starts at the lowest rowid, which may be negative
+		{"SELECT * FROM t WHERE a = 1", "SELECT *, t.rowid FROM t WHERE a = 1 ORDER BY t.rowid LIMIT 3", true},
+		{"SELECT a, b FROM main.t AS x WHERE a = ? OR b = 2", "SELECT a, b, x.rowid FROM main.t AS x WHERE ( a = ? OR b = 2\n) AND x.rowid > 5 ORDER BY x.rowid LIMIT 3", false},
+		{"SELECT (SELECT max(id) FROM u ORDER BY id) FROM t", "SELECT (SELECT max(id) FROM u ORDER BY id), t.rowid FROM t WHERE t.rowid > 5 ORDER BY t.rowid LIMIT 3", false},
+		{"SELECT * FROM t ORDER BY name", "", false},
+		{"SELECT * FROM t JOIN u ON t.id = u.id", "", false},
+		{"SELECT * FROM t, u", "", false},
+		{"SELECT * FROM (SELECT * FROM t) AS s", "", false},
+		{"SELECT DISTINCT a FROM t", "", false},
+		{"SELECT a FROM t UNION SELECT a FROM u", "", false},
+		{"PRAGMA table_info(t)", "", false},
+		// aggregates return one row for the whole table
+		{"SELECT count(*) FROM t", "", true},
+		{"SELECT (max(id) + 1) AS next FROM t", "", false},
+		{"SELECT a, b FROM t GROUP BY a HAVING count(*) > 1", "", false},
+	}
+	for _, tt := range tests {
+		after := new(int64)
+		*after = 5
+		if tt.first {
+			after = nil
+		}
+		got, ok := keysetQuery(tt.sql, after, 3)
+		if ok != (tt.want != "") || got != tt.want {
+			t.Errorf("keysetQuery(%q) = %q, %v; want %q", tt.sql, got, ok, tt.want)
+		}
+	}
+}
+
+func TestOffsetQuery(t *testing.T) {
+	if got := offsetQuery("SELECT * FROM t ORDER BY name", 20, 11); got != "SELECT * FROM t ORDER BY name\nLIMIT 11 OFFSET 20" {
+		t.Errorf("got %q", got)
+	}
+	if got := offsetQuery("SELECT * FROM t LIMIT 100", 20, 11); got != "SELECT * FROM (SELECT * FROM t LIMIT 100\n) LIMIT 11 OFFSET 20" {
+		t.Errorf("got %q", got)
+	}
+}
+
+func newPageExecuter(t *testing.T) *Executer {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "page.db")
+	stmts := []string{
+		"CREATE TABLE t (id INTEGER PRIMARY KEY, name TEXT)",
+		"CREATE VIEW v AS SELECT id, name FROM t",
+	}
+	for _, i := range []int{-2, 0, 1, 2, 3, 4, 5, 6, 7} {
+		stmts = append(stmts, fmt.Sprintf("INSERT INTO t VALUES (%d, 'n%d')", i, i))
+	}
+	if err := (&Executer{db: path}).Exec(stmts); err != nil {
+		t.Fatal(err)
+	}
+	e, err := NewExecuter(path, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { e.Close() })
+	return e
+}
+
+// readPages pages through cur and returns the ids seen on every page
+func readPages(t *testing.T, e *Executer, cur *Cursor) ([][]int64, []*Cursor) {
+	t.Helper()
+	var pages [][]int64
+	var cursors []*Cursor
+	for cur != nil {
+		res, next, err := e.QueryPage(context.Background(), cur)
+		if err != nil {
+			t.Fatalf("QueryPage failed: %v", err)
+		}
+		if !reflect.DeepEqual(res.Columns, []string{"id", "name"}) {
+			t.Fatalf("got columns %v", res.Columns)
+		}
+		var ids []int64
+		for _, row := range res.Values {
+			ids = append(ids, row[0].(int64))
+		}
+		pages = append(pages, ids)
+		if next != nil {
+			cursors = append(cursors, next)
+		}
+		cur = next
+	}
+	return pages, cursors
+}
+
+func TestQueryPage(t *testing.T) {
+	e := newPageExecuter(t)
+	want := [][]int64{{2, 3, 4}, {5, 6, 7}}
+
+	for _, tt := range []struct {
+		sql    string
+		arg    int
+		keyset bool
+		want   [][]int64
+	}{
+		{"SELECT id, name FROM t WHERE id > ?;", 1, true, want},
+		{"SELECT id, name FROM t WHERE id > ? ORDER BY id", 1, false, want},
+		// views have no rowid
+		{"SELECT id, name FROM v WHERE id > ?", 1, false, want},
+		// rowids below 1 are on the first page
+		{"SELECT id, name FROM t WHERE id < ?", 5, true, [][]int64{{-2, 0, 1}, {2, 3, 4}}},
+		// an aggregate is a single row
+		{"SELECT count(*) AS id, 'all' AS name FROM t WHERE id > ?", -10, false, [][]int64{{9}}},
+	} {
+		pages, cursors := readPages(t, e, &Cursor{SQL: tt.sql, Args: []interface{}{tt.arg}, PageSize: 3})
+		if !reflect.DeepEqual(pages, tt.want) {
+			t.Errorf("%s: got pages %v, want %v", tt.sql, pages, tt.want)
+		}
+		if len(cursors) != len(tt.want)-1 || (len(cursors) > 0 && cursors[0].Keyset != tt.keyset) {
+			t.Errorf("%s: got cursors %+v, want keyset %v", tt.sql, cursors, tt.keyset)
+		}
+	}
+
+	if _, _, err := e.QueryPage(context.Background(), &Cursor{SQL: "SELECT 1; SELECT 2", PageSize: 3}); !errors.Is(err, ErrNotPageable) {
+		t.Errorf("got %v, want ErrNotPageable", err)
+	}
+}
+
+func TestCursors(t *testing.T) {
+	s := NewCursors(time.Minute)
+	token, err := s.Put(&Cursor{DB: "db1", SQL: "SELECT 1", PageSize: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.ContainsAny(token, "+/=") {
+		t.Errorf("token %q is not URL safe", token)
+	}
+	for i := 0; i < 2; i++ {
+		cur, err := s.Get(token)
+		if err != nil || cur.SQL != "SELECT 1" {
+			t.Fatalf("Get = %+v, %v", cur, err)
+		}
+	}
+
+	token, _ = s.Put(&Cursor{DB: "db1"})
+	s.Remove("db1")
+	if _, err := s.Get(token); !errors.Is(err, ErrCursorNotFound) {
+		t.Fatalf("got %v after Remove, want ErrCursorNotFound", err)
+	}
+
+	expired := NewCursors(-time.Second)
+	token, _ = expired.Put(&Cursor{DB: "db1"})
+	if _, err := expired.Get(token); !errors.Is(err, ErrCursorNotFound) {
+		t.Fatalf("got %v for an expired cursor, want ErrCursorNotFound", err)
+	}
+}
@@ -0,0 +1,125 @@
+package executer
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/mattn/go-sqlite3"
+
+	"rflite/pkg"
+)
+
+// ErrNotReadOnly is returned for a query with a statement that writes
+// the database or changes the state of the connection
+var ErrNotReadOnly = errors.New("statement is not read-only, use /exec")
+
+// connStateWords start statements that sqlite3_stmt_readonly accepts but
+// that change a pooled connection for every later query
+var connStateWords = []string{"ATTACH", "DETACH", "BEGIN", "COMMIT", "END", "ROLLBACK", "SAVEPOINT", "RELEASE"}
+
+// pragmaQueries are the pragmas whose argument selects what to read
+// rather than a new value
+var pragmaQueries = map[string]bool{
+	"table_info":        true,
+	"table_xinfo":       true,
+	"table_list":        true,
+	"index_info":        true,
+	"index_xinfo":       true,
+	"index_list":        true,
+	"foreign_key_list":  true,
+	"foreign_key_check": true,
+	"integrity_check":   true,
+	"quick_check":       true,
+}
+
+// checkReadOnly prepares every statement of sqlStr on conn and fails with
+// ErrNotReadOnly unless all of them are read-only. It returns the
+// statements it checked.
+func checkReadOnly(ctx context.Context, conn *sql.Conn, sqlStr string) ([]pkg.Statement, error) {
+	stmts, err := pkg.SplitStatements(sqlStr)
+	if err != nil {
+		return nil, err
+	}
+	for _, stmt := range stmts {
+		if err := checkStatement(stmt); err != nil {
+			return nil, err
+		}
+		err := conn.Raw(func(dc interface{}) error {
+			c, ok := dc.(*sqlite3.SQLiteConn)
+			if !ok {
+				return fmt.Errorf("unexpected driver connection %T", dc)
+			}
+			st, err := c.Prepare(stmt.SQL)
+			if err != nil {
+				return err
+			}
+			defer st.Close()
+			if !st.(*sqlite3.SQLiteStmt).Readonly() {
+				return fmt.Errorf("%w: %s", ErrNotReadOnly, firstWord(stmt))
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, queryErr(ctx, err)
+		}
+	}
+	return stmts, nil
+}
+
+// ReadOnly reports whether every statement of sqlStr is read-only,
+// without running it
+func (e *Executer) ReadOnly(ctx context.Context, sqlStr string) (bool, error) {
+	select {
+	case e.slots <- struct{}{}:
+		defer func() { <-e.slots }()
+	default:
+		return false, ErrBusy
+	}
+	rc, err := e.acquire(ctx)
+	if err != nil {
+		return false, queryErr(ctx, err)
+	}
+	_, err = checkReadOnly(ctx, rc.conn, sqlStr)
+	e.release(rc, err)
+	switch {
+	case errors.Is(err, ErrNotReadOnly):
+		return false, nil
+	case err != nil:
+		return false, err
+	}
+	return true, nil
+}
+
+// checkStatement rejects the statements that sqlite3_stmt_readonly lets
+// through but that change the connection: ATTACH, transaction control and
+// PRAGMAs setting a value
+func checkStatement(stmt pkg.Statement) error {
+	tokens := stmt.Tokens()
+	for _, word := range connStateWords {
+		if tokens[0].Is(word) {
+			return fmt.Errorf("%w: %s", ErrNotReadOnly, firstWord(stmt))
+		}
+	}
+	if !tokens[0].Is("PRAGMA") {
+		return nil
+	}
+	// PRAGMA [schema.]name [= value | (value)]
+	sig := tokens[1:]
+	if len(sig) >= 2 && sig[1].Text == "." {
+		sig = sig[2:]
+	}
+	if len(sig) < 2 {
+		return nil
+	}
+	if sig[1].Text == "(" && pragmaQueries[strings.ToLower(sig[0].Text)] {
+		return nil
+	}
+	return fmt.Errorf("%w: PRAGMA %s sets a value", ErrNotReadOnly, sig[0].Text)
+}
+
+func firstWord(stmt pkg.Statement) string {
+	return strings.ToUpper(stmt.Tokens()[0].Text)
+}
@@ -0,0 +1,70 @@
+package executer
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+func TestExecQueryRejectsWrites(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "ro.db")
+	if err := (&Executer{db: path}).Exec([]string{"CREATE TABLE t (id INTEGER)"}); err != nil {
+		t.Fatal(err)
+	}
+	e, err := NewExecuter(path, Options{ReadConns: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer e.Close()
+	ctx := context.Background()
+
+	for _, q := range []string{
+		"SELECT * FROM t",
+		"SELECT 1; SELECT 2",
+		"PRAGMA user_version",
+		"PRAGMA main.table_info(t)",
+		"SELECT 'ATTACH' -- BEGIN",
+	} {
+		if _, err := e.ExecQuery(ctx, q); err != nil {
+			t.Errorf("ExecQuery(%q) failed: %v", q, err)
+		}
+	}
+
+	for _, q := range []string{
+		"INSERT INTO t VALUES (1)",
+		"CREATE TEMP TABLE x (id INTEGER)",
+		"ATTACH '" + filepath.Join(dir, "other.db") + "' AS other",
+		"BEGIN",
+		"PRAGMA cache_size = 10",
+		"PRAGMA cache_size(10)",
+		"PRAGMA user_version = 3",
+		"SELECT 1; DETACH other",
+		// the semicolons of a trigger body do not end the statement
+		"CREATE TRIGGER tr AFTER INSERT ON t BEGIN DELETE FROM t; SELECT 1; END",
+	} {
+		if _, err := e.ExecQuery(ctx, q); !errors.Is(err, ErrNotReadOnly) {
+			t.Errorf("ExecQuery(%q) = %v, want ErrNotReadOnly", q, err)
+		}
+	}
+	// route_writes forwards what ReadOnly reports as a write
+	for q, want := range map[string]bool{
+		"SELECT * FROM t": true,
+		"DELETE FROM t":   false,
+		"SELECT 1; BEGIN": false,
+		"CREATE TRIGGER tr AFTER INSERT ON t BEGIN SELECT 1; END": false,
+	} {
+		if got, err := e.ReadOnly(ctx, q); err != nil || got != want {
+			t.Errorf("ReadOnly(%q) = %v, %v; want %v", q, got, err, want)
+		}
+	}
+	if _, err := e.Stream(ctx, "DELETE FROM t"); !errors.Is(err, ErrNotReadOnly) {
+		t.Errorf("Stream = %v, want ErrNotReadOnly", err)
+	}
+
+	// rejected queries gave their connection back
+	if _, err := e.ExecQuery(ctx, "SELECT count(*) FROM t"); err != nil {
+		t.Fatalf("ExecQuery failed: %v", err)
+	}
+}
@@ -0,0 +1,99 @@
+package executer
+
+import (
+	"errors"
+	"sync"
+)
+
+var ErrRegistryClosed = errors.New("executer registry is closed")
+
+// Registry keeps one Executer per database, opened on first use
+type Registry struct {
+	path func(dbID string) string
+	opts Options
+
+	mu        sync.Mutex
+	executers map[string]*Executer
+	closed    bool
+}
+
+// NewRegistry creates a registry that finds the file of a database with
+// path and opens its Executer with opts
+func NewRegistry(path func(dbID string) string, opts Options) *Registry {
+	return &Registry{
+		path:      path,
+		opts:      opts,
+		executers: make(map[string]*Executer),
+	}
+}
+
+// Get returns the Executer of a database, opening it if needed
+func (r *Registry) Get(dbID string) (*Executer, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
+		return nil, ErrRegistryClosed
+	}
+	if e, ok := r.executers[dbID]; ok {
+		return e, nil
+	}
+	e, err := NewExecuter(r.path(dbID), r.opts)
+	if err != nil {
+		return nil, err
+	}
+	r.executers[dbID] = e
+	return e, nil
+}
+
+// InvalidateStatements drops the cached prepared statements of a
+// database, if its Executer is open
+func (r *Registry) InvalidateStatements(dbID string) {
+	r.mu.Lock()
+	e, ok := r.executers[dbID]
+	r.mu.Unlock()
+	if ok {
+		e.InvalidateStatements()
+	}
+}
+
+// StmtCacheStats returns the prepared statement cache counters of a
+// database, false if its Executer is not open
+func (r *Registry) StmtCacheStats(dbID string) (StmtCacheStats, bool) {
+	r.mu.Lock()
+	e, ok := r.executers[dbID]
+	r.mu.Unlock()
+	if !ok {
+		return StmtCacheStats{}, false
+	}
+	return e.StmtCacheStats(), true
+}
+
+// Remove closes the Executer of a database. The next Get opens a new one,
+// so call it whenever the database file is deleted or replaced.
+func (r *Registry) Remove(dbID string) error {
+	r.mu.Lock()
+	e, ok := r.executers[dbID]
+	delete(r.executers, dbID)
+	r.mu.Unlock()
+	if !ok {
+		return nil
+	}
+	return e.Close()
+}
+
+// Close closes every Executer, Get fails afterwards
+func (r *Registry) Close() error {
+	r.mu.Lock()
+	executers := r.executers
+	r.executers = make(map[string]*Executer)
+	r.closed = true
+	r.mu.Unlock()
+
+	var firstErr error
+	for _, e := range executers {
+		if err := e.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
@@ -0,0 +1,77 @@
+package executer
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+func TestRegistry(t *testing.T) {
+	dir := t.TempDir()
+	path := func(dbID string) string { return filepath.Join(dir, dbID+".db") }
+	if err := (&Executer{db: path("db1")}).Exec([]string{"CREATE TABLE t (id INTEGER)", "INSERT INTO t VALUES (1)"}); err != nil {
+		t.Fatal(err)
+	}
+
+	r := NewRegistry(path, Options{ReadConns: 2})
+	e, err := r.Get("db1")
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	if again, _ := r.Get("db1"); again != e {
+		t.Fatal("expected Get to reuse the executer")
+	}
+	res, err := e.ExecQuery(context.Background(), "SELECT id FROM t")
+	if err != nil || len(res.Values) != 1 {
+		t.Fatalf("ExecQuery = %v, %v", res, err)
+	}
+
+	if err := r.Remove("db1"); err != nil {
+		t.Fatalf("Remove failed: %v", err)
+	}
+	if _, err := e.ExecQuery(context.Background(), "SELECT id FROM t"); err == nil {
+		t.Fatal("expected removed executer to be closed")
+	}
+	reopened, err := r.Get("db1")
+	if err != nil || reopened == e {
+		t.Fatalf("expected a new executer, got %v", err)
+	}
+
+	if _, err := r.Get("missing"); err == nil {
+		t.Fatal("expected error for a missing database file")
+	}
+
+	if err := r.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	if _, err := reopened.ExecQuery(context.Background(), "SELECT 1"); err == nil {
+		t.Fatal("expected executer to be closed with the registry")
+	}
+	if _, err := r.Get("db1"); !errors.Is(err, ErrRegistryClosed) {
+		t.Fatalf("got %v, want ErrRegistryClosed", err)
+	}
+}
+
+func TestExecQueryBusy(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "busy.db")
+	if err := (&Executer{db: path}).Exec([]string{"CREATE TABLE t (id INTEGER)"}); err != nil {
+		t.Fatal(err)
+	}
+	e, err := NewExecuter(path, Options{ReadConns: 1, MaxQueued: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer e.Close()
+
+	// take every slot as if two queries were running or queued
+	e.slots <- struct{}{}
+	e.slots <- struct{}{}
+	if _, err := e.ExecQuery(context.Background(), "SELECT 1"); !errors.Is(err, ErrBusy) {
+		t.Fatalf("got %v, want ErrBusy", err)
+	}
+	<-e.slots
+	if _, err := e.ExecQuery(context.Background(), "SELECT 1"); err != nil {
+		t.Fatalf("ExecQuery failed: %v", err)
+	}
+}
@@ -0,0 +1,113 @@
+package executer
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+)
+
+// Format is the shape of a query result in a response
+type Format string
+
+const (
+	// FormatRows returns every row as an object keyed by column name
+	FormatRows Format = "rows"
+	// FormatColumnar returns the column names once and every row as an
+	// array in column order
+	FormatColumnar Format = "columnar"
+)
+
+var ErrInvalidFormat = errors.New("invalid result format")
+
+// ParseFormat parses the format of a result, FormatRows when empty
+func ParseFormat(s string) (Format, error) {
+	switch f := Format(s); f {
+	case "":
+		return FormatRows, nil
+	case FormatRows, FormatColumnar:
+		return f, nil
+	default:
+		return "", fmt.Errorf("%w %q", ErrInvalidFormat, s)
+	}
+}
+
+// Result is the outcome of a query in the columnar format. Values keep
+// their SQLite storage class: integers and reals are JSON numbers, NULL
+// is null, text is a string and blobs are base64 encoded by
+// encoding/json.
+type Result struct {
+	Columns []string `json:"columns"`
+	// Types are the declared types of the columns, "" for expressions
+	Types  []string        `json:"types"`
+	Values [][]interface{} `json:"values"`
+}
+
+// RowsResult is a Result in the rows format
+type RowsResult struct {
+	Columns []string                 `json:"columns"`
+	Types   []string                 `json:"types"`
+	Rows    []map[string]interface{} `json:"rows"`
+}
+
+// Encode returns r in format, ready to be marshalled
+func (r *Result) Encode(format Format) interface{} {
+	if format == FormatColumnar {
+		return r
+	}
+	return &RowsResult{Columns: r.Columns, Types: r.Types, Rows: toMaps(r.Columns, r.Values)}
+}
+
+func toMaps(columns []string, values [][]interface{}) []map[string]interface{} {
+	if values == nil {
+		return nil
+	}
+	maps := make([]map[string]interface{}, len(values))
+	for i, row := range values {
+		m := make(map[string]interface{}, len(columns))
+		for j, col := range columns {
+			m[col] = row[j]
+		}
+		maps[i] = m
+	}
+	return maps
+}
+
+// columnTypes returns the declared type of every column of rows
+func columnTypes(rows *sql.Rows) ([]string, error) {
+	cts, err := rows.ColumnTypes()
+	if err != nil {
+		return nil, err
+	}
+	types := make([]string, len(cts))
+	for i, ct := range cts {
+		types[i] = ct.DatabaseTypeName()
+	}
+	return types, nil
+}
+
+// value converts a scanned value into what the result carries
+func value(v interface{}) interface{} {
+	switch v := v.(type) {
+	case time.Time:
+		// the driver parses DATE, DATETIME and TIMESTAMP columns, give
+		// the client SQLite's own text format again
+		layout := "2006-01-02 15:04:05.999999999"
+		if _, offset := v.Zone(); offset != 0 {
+			layout += "-07:00"
+		}
+		return v.Format(layout)
+	case float64:
+		// JSON has no infinities, SQLite returns them for overflows
+		if math.IsInf(v, 1) {
+			return "Inf"
+		}
+		if math.IsInf(v, -1) {
+			return "-Inf"
+		}
+		return v
+	default:
+		return v
+	}
+}
@@ -0,0 +1,76 @@
+package executer
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+func TestExecQueryKeepsTypes(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "types.db")
+	if err := (&Executer{db: path}).Exec([]string{
+		"CREATE TABLE t (i INTEGER, r REAL, s TEXT, b BLOB, n TEXT, d DATETIME)",
+		"INSERT INTO t VALUES (9007199254740993, 1.5, 'x', X'00FF', NULL, '2024-05-06 07:08:09')",
+	}); err != nil {
+		t.Fatal(err)
+	}
+	e, err := NewExecuter(path, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer e.Close()
+
+	res, err := e.ExecQuery(context.Background(), "SELECT i, r, s, b, n, d, i + 1 AS next FROM t")
+	if err != nil {
+		t.Fatalf("ExecQuery failed: %v", err)
+	}
+	data, err := json.Marshal(res.Encode(FormatRows))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"columns":["i","r","s","b","n","d","next"],` +
+		`"types":["INTEGER","REAL","TEXT","BLOB","TEXT","DATETIME",""],` +
+		`"rows":[{"b":"AP8=","d":"2024-05-06 07:08:09","i":9007199254740993,"n":null,"next":9007199254740994,"r":1.5,"s":"x"}]}`
+	if string(data) != want {
+		t.Fatalf("got  %s\nwant %s", data, want)
+	}
+}
+
+func TestResultColumnar(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "columnar.db")
+	if err := (&Executer{db: path}).Exec([]string{
+		"CREATE TABLE t (id INTEGER, name TEXT)",
+		"INSERT INTO t VALUES (1, 'a'), (2, NULL)",
+	}); err != nil {
+		t.Fatal(err)
+	}
+	e, err := NewExecuter(path, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer e.Close()
+
+	// duplicate names and order survive the columnar format
+	res, err := e.ExecQuery(context.Background(), "SELECT name, id, id FROM t ORDER BY id")
+	if err != nil {
+		t.Fatalf("ExecQuery failed: %v", err)
+	}
+	format, err := ParseFormat("columnar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := json.Marshal(res.Encode(format))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"columns":["name","id","id"],"types":["TEXT","INTEGER","INTEGER"],"values":[["a",1,1],[null,2,2]]}`
+	if string(data) != want {
+		t.Fatalf("got  %s\nwant %s", data, want)
+	}
+
+	if _, err := ParseFormat("xml"); !errors.Is(err, ErrInvalidFormat) {
+		t.Fatalf("got %v, want ErrInvalidFormat", err)
+	}
+}
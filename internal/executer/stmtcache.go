@@ -0,0 +1,167 @@
+package executer
+
+import (
+	"container/list"
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+)
+
+// StmtCacheStats counts the lookups in the prepared statement caches of
+// an Executer
+type StmtCacheStats struct {
+	Hits   uint64 `json:"hits"`
+	Misses uint64 `json:"misses"`
+}
+
+// StmtCacheStats returns the hit and miss counters of the prepared
+// statement caches of every read connection
+func (e *Executer) StmtCacheStats() StmtCacheStats {
+	return StmtCacheStats{Hits: e.hits.Load(), Misses: e.misses.Load()}
+}
+
+// InvalidateStatements drops every cached prepared statement. Connections
+// clear their cache the next time they run a query.
+func (e *Executer) InvalidateStatements() {
+	e.schema.Add(1)
+}
+
+// readConn is a read connection kept out of the database/sql pool for the
+// lifetime of the Executer, so its prepared statements stay valid
+type readConn struct {
+	conn  *sql.Conn
+	stmts *stmtCache
+}
+
+// acquire returns an idle read connection or opens one, waiting for a
+// free one once Options.ReadConns are open
+func (e *Executer) acquire(ctx context.Context) (*readConn, error) {
+	e.mu.Lock()
+	if e.closed {
+		e.mu.Unlock()
+		return nil, sql.ErrConnDone
+	}
+	if n := len(e.idle); n > 0 {
+		rc := e.idle[n-1]
+		e.idle = e.idle[:n-1]
+		e.mu.Unlock()
+		return rc, nil
+	}
+	e.mu.Unlock()
+
+	conn, err := e.read.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &readConn{conn: conn, stmts: newStmtCache(e.stmtCacheSize)}, nil
+}
+
+// release hands rc back to the idle list. err is the last error seen on
+// it; a broken connection is closed instead, freeing its place in the
+// pool.
+func (e *Executer) release(rc *readConn, err error) {
+	e.mu.Lock()
+	if !e.closed && !errors.Is(err, driver.ErrBadConn) && !errors.Is(err, sql.ErrConnDone) {
+		e.idle = append(e.idle, rc)
+		e.mu.Unlock()
+		return
+	}
+	e.mu.Unlock()
+	rc.close()
+}
+
+func (rc *readConn) close() {
+	rc.stmts.clear()
+	rc.conn.Close()
+}
+
+// prepare returns the cached statement of sqlStr on rc, preparing and
+// caching it once every statement of sqlStr proved read-only. It returns
+// a nil statement for SQL that is read-only but cannot be cached, such as
+// several statements at once; run that on rc.conn directly.
+func (e *Executer) prepare(ctx context.Context, rc *readConn, sqlStr string) (*sql.Stmt, error) {
+	if e.stmtCacheSize <= 0 {
+		_, err := checkReadOnly(ctx, rc.conn, sqlStr)
+		return nil, err
+	}
+	if schema := e.schema.Load(); schema != rc.stmts.schema {
+		rc.stmts.clear()
+		rc.stmts.schema = schema
+	}
+	if stmt := rc.stmts.get(sqlStr); stmt != nil {
+		e.hits.Add(1)
+		return stmt, nil
+	}
+	e.misses.Add(1)
+
+	stmts, err := checkReadOnly(ctx, rc.conn, sqlStr)
+	if err != nil {
+		return nil, err
+	}
+	// a prepared statement only runs the first statement of its SQL
+	if len(stmts) != 1 {
+		return nil, nil
+	}
+	stmt, err := rc.conn.PrepareContext(ctx, sqlStr)
+	if err != nil {
+		return nil, queryErr(ctx, err)
+	}
+	rc.stmts.put(sqlStr, stmt)
+	return stmt, nil
+}
+
+// stmtCache is a least recently used cache of the prepared statements of
+// one connection, keyed by SQL text
+type stmtCache struct {
+	size int
+	// schema is the Executer.schema the statements were prepared under
+	schema uint64
+	// order has the most recently used statement at the front
+	order *list.List
+	stmts map[string]*list.Element
+}
+
+type cachedStmt struct {
+	sql  string
+	stmt *sql.Stmt
+}
+
+func newStmtCache(size int) *stmtCache {
+	return &stmtCache{
+		size:  size,
+		order: list.New(),
+		stmts: make(map[string]*list.Element),
+	}
+}
+
+func (c *stmtCache) get(sqlStr string) *sql.Stmt {
+	el, ok := c.stmts[sqlStr]
+	if !ok {
+		return nil
+	}
+	c.order.MoveToFront(el)
+	return el.Value.(*cachedStmt).stmt
+}
+
+// put caches stmt, closing the least recently used statement when the
+// cache is full
+func (c *stmtCache) put(sqlStr string, stmt *sql.Stmt) {
+	c.stmts[sqlStr] = c.order.PushFront(&cachedStmt{sql: sqlStr, stmt: stmt})
+	if c.order.Len() <= c.size {
+		return
+	}
+	oldest := c.order.Back()
+	c.order.Remove(oldest)
+	cached := oldest.Value.(*cachedStmt)
+	delete(c.stmts, cached.sql)
+	cached.stmt.Close()
+}
+
+func (c *stmtCache) clear() {
+	for el := c.order.Front(); el != nil; el = el.Next() {
+		el.Value.(*cachedStmt).stmt.Close()
+	}
+	c.order.Init()
+	clear(c.stmts)
+}
@@ -0,0 +1,65 @@
+package executer
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+)
+
+func TestStmtCache(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache.db")
+	if err := (&Executer{db: path}).Exec([]string{"CREATE TABLE t (id INTEGER)", "INSERT INTO t VALUES (1)"}); err != nil {
+		t.Fatal(err)
+	}
+	e, err := NewExecuter(path, Options{ReadConns: 1, StmtCacheSize: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer e.Close()
+	ctx := context.Background()
+	query := func(sqlStr string) *Result {
+		t.Helper()
+		res, err := e.ExecQuery(ctx, sqlStr)
+		if err != nil {
+			t.Fatalf("ExecQuery(%q) failed: %v", sqlStr, err)
+		}
+		return res
+	}
+	expect := func(hits, misses uint64) {
+		t.Helper()
+		if got := e.StmtCacheStats(); got != (StmtCacheStats{Hits: hits, Misses: misses}) {
+			t.Fatalf("got %+v, want %d hits and %d misses", got, hits, misses)
+		}
+	}
+
+	query("SELECT * FROM t")
+	query("SELECT * FROM t")
+	expect(1, 1)
+
+	// the least recently used statement is evicted
+	query("SELECT id FROM t")
+	query("SELECT * FROM t")
+	query("SELECT count(*) FROM t")
+	query("SELECT id FROM t")
+	expect(2, 4)
+
+	// several statements are checked every time
+	query("SELECT 1; SELECT 2")
+	query("SELECT 1; SELECT 2")
+	expect(2, 6)
+
+	if err := (&Executer{db: path}).Exec([]string{"ALTER TABLE t ADD COLUMN name TEXT"}); err != nil {
+		t.Fatal(err)
+	}
+	e.InvalidateStatements()
+	// the connection reloads the schema while running the first query
+	// after the change, so only the next one names the new column
+	query("SELECT * FROM t")
+	if res := query("SELECT * FROM t"); len(res.Columns) != 2 {
+		t.Fatalf("expected the new column, got %v", res.Columns)
+	}
+	expect(3, 7)
+	if _, err := e.ExecQuery(ctx, "DELETE FROM t"); err == nil {
+		t.Fatal("expected a write to be rejected")
+	}
+}
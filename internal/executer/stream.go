@@ -0,0 +1,87 @@
+package executer
+
+import (
+	"context"
+	"database/sql"
+	"sync"
+)
+
+// Stream reads the rows of a query one at a time instead of loading the
+// whole result. It holds a read connection until Close.
+type Stream struct {
+	Columns []string
+	Types   []string
+
+	ctx     context.Context
+	rows    *sql.Rows
+	dest    []interface{}
+	values  []interface{}
+	err     error
+	release func()
+	once    sync.Once
+}
+
+// Stream starts a query and returns its rows as a Stream. Cancelling ctx
+// interrupts the query, so a reader that goes away stops the scan, and
+// its deadline fails the scan with ErrTimeout.
+func (e *Executer) Stream(ctx context.Context, sqlStr string, args ...interface{}) (*Stream, error) {
+	rows, release, err := e.query(ctx, sqlStr, args)
+	if err != nil {
+		return nil, err
+	}
+	s := &Stream{ctx: ctx, rows: rows, release: release}
+	if s.Columns, err = rows.Columns(); err != nil {
+		s.Close()
+		return nil, err
+	}
+	if s.Types, err = columnTypes(rows); err != nil {
+		s.Close()
+		return nil, err
+	}
+	s.dest = make([]interface{}, len(s.Columns))
+	for i := range s.dest {
+		s.dest[i] = new(interface{})
+	}
+	s.values = make([]interface{}, len(s.Columns))
+	return s, nil
+}
+
+// Next scans the next row, it returns false at the end of the result or
+// on an error reported by Err
+func (s *Stream) Next() bool {
+	if !s.rows.Next() {
+		return false
+	}
+	if err := s.rows.Scan(s.dest...); err != nil {
+		s.err = err
+		return false
+	}
+	for i := range s.values {
+		s.values[i] = value(*s.dest[i].(*interface{}))
+	}
+	return true
+}
+
+// Values returns the row scanned by Next, in column order. The slice is
+// reused by the next call to Next.
+func (s *Stream) Values() []interface{} {
+	return s.values
+}
+
+// Err returns the error that stopped Next, if any
+func (s *Stream) Err() error {
+	if s.err != nil {
+		return queryErr(s.ctx, s.err)
+	}
+	if err := s.rows.Err(); err != nil {
+		return queryErr(s.ctx, err)
+	}
+	return nil
+}
+
+// Close stops the query and frees its connection
+func (s *Stream) Close() error {
+	err := s.rows.Close()
+	s.once.Do(s.release)
+	return err
+}
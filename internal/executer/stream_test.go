@@ -0,0 +1,110 @@
+package executer
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func newStreamExecuter(t *testing.T) *Executer {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "stream.db")
+	if err := (&Executer{db: path}).Exec([]string{
+		"CREATE TABLE t (id INTEGER, name TEXT)",
+		"INSERT INTO t VALUES (1, 'a'), (2, NULL)",
+	}); err != nil {
+		t.Fatal(err)
+	}
+	e, err := NewExecuter(path, Options{ReadConns: 1, MaxQueued: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { e.Close() })
+	return e
+}
+
+func TestStream(t *testing.T) {
+	e := newStreamExecuter(t)
+	s, err := e.Stream(context.Background(), "SELECT id, name FROM t WHERE id > ? ORDER BY id", 0)
+	if err != nil {
+		t.Fatalf("Stream failed: %v", err)
+	}
+	if !reflect.DeepEqual(s.Columns, []string{"id", "name"}) || !reflect.DeepEqual(s.Types, []string{"INTEGER", "TEXT"}) {
+		t.Fatalf("got columns %v types %v", s.Columns, s.Types)
+	}
+	var got [][]interface{}
+	for s.Next() {
+		got = append(got, append([]interface{}(nil), s.Values()...))
+	}
+	if err := s.Err(); err != nil {
+		t.Fatalf("Err = %v", err)
+	}
+	want := [][]interface{}{{int64(1), "a"}, {int64(2), nil}}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	s.Close()
+	s.Close()
+
+	// Close gave the slots back
+	for i := 0; i < 3; i++ {
+		if _, err := e.ExecQuery(context.Background(), "SELECT 1"); err != nil {
+			t.Fatalf("ExecQuery after Close failed: %v", err)
+		}
+	}
+}
+
+func TestStreamCancel(t *testing.T) {
+	e := newStreamExecuter(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s, err := e.Stream(ctx, "WITH RECURSIVE n(i) AS (SELECT 1 UNION ALL SELECT i + 1 FROM n) SELECT i FROM n")
+	if err != nil {
+		t.Fatalf("Stream failed: %v", err)
+	}
+	defer s.Close()
+
+	rows := 0
+	for s.Next() {
+		rows++
+		if rows == 10 {
+			cancel()
+		}
+		if rows > 1000000 {
+			t.Fatal("query was not cancelled")
+		}
+	}
+	if s.Err() == nil {
+		t.Fatal("expected an error after cancel")
+	}
+}
+
+func TestQueryTimeout(t *testing.T) {
+	e := newStreamExecuter(t)
+	endless := "WITH RECURSIVE n(i) AS (SELECT 1 UNION ALL SELECT i + 1 FROM n) SELECT count(*) FROM n"
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, err := e.ExecQuery(ctx, endless); !errors.Is(err, ErrTimeout) {
+		t.Fatalf("got %v, want ErrTimeout", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Fatalf("query was interrupted after %v", elapsed)
+	}
+
+	// a cancelled query is not a timeout
+	ctx, cancel = context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	if _, err := e.ExecQuery(ctx, endless); err == nil || errors.Is(err, ErrTimeout) {
+		t.Fatalf("got %v, want a cancellation error", err)
+	}
+
+	// the connection is usable again
+	if _, err := e.ExecQuery(context.Background(), "SELECT 1"); err != nil {
+		t.Fatalf("ExecQuery after timeout failed: %v", err)
+	}
+}
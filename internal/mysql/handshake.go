@@ -0,0 +1,163 @@
+package mysql
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/binary"
+	"fmt"
+)
+
+// Capability flags of the client/server protocol
+const (
+	clientLongPassword               = 0x00000001
+	clientFoundRows                  = 0x00000002
+	clientLongFlag                   = 0x00000004
+	clientConnectWithDB              = 0x00000008
+	clientProtocol41                 = 0x00000200
+	clientTransactions               = 0x00002000
+	clientSecureConnection           = 0x00008000
+	clientMultiStatements            = 0x00010000
+	clientMultiResults               = 0x00020000
+	clientPluginAuth                 = 0x00080000
+	clientConnectAttrs               = 0x00100000
+	clientPluginAuthLenEncClientData = 0x00200000
+
+	serverCapabilities = clientLongPassword | clientFoundRows | clientLongFlag |
+		clientConnectWithDB | clientProtocol41 | clientTransactions |
+		clientSecureConnection | clientMultiStatements | clientMultiResults |
+		clientPluginAuth | clientConnectAttrs | clientPluginAuthLenEncClientData
+)
+
+const (
+	nativePassword = "mysql_native_password"
+	// charsetUTF8MB4 is utf8mb4_general_ci, SQLite text is UTF-8
+	charsetUTF8MB4 = 45
+	// charsetBinary marks BLOB columns
+	charsetBinary = 63
+)
+
+// handshake greets the client and authenticates it. It returns the
+// capabilities both sides share and the database the client connected
+// with, if any.
+func (c *conn) handshake() (uint32, string, error) {
+	scramble := make([]byte, 20)
+	if _, err := rand.Read(scramble); err != nil {
+		return 0, "", err
+	}
+	// the scramble is sent as a NUL-terminated string, keep it printable
+	for i := range scramble {
+		scramble[i] = scramble[i]%94 + 33
+	}
+
+	greeting := []byte{10}
+	greeting = append(greeting, serverVersion...)
+	greeting = append(greeting, 0)
+	greeting = binary.LittleEndian.AppendUint32(greeting, c.id)
+	greeting = append(greeting, scramble[:8]...)
+	greeting = append(greeting, 0)
+	greeting = binary.LittleEndian.AppendUint16(greeting, uint16(serverCapabilities&0xffff))
+	greeting = append(greeting, charsetUTF8MB4)
+	greeting = binary.LittleEndian.AppendUint16(greeting, statusAutocommit)
+	greeting = binary.LittleEndian.AppendUint16(greeting, uint16(serverCapabilities>>16))
+	greeting = append(greeting, byte(len(scramble)+1))
+	greeting = append(greeting, make([]byte, 10)...)
+	greeting = append(greeting, scramble[8:]...)
+	greeting = append(greeting, 0)
+	greeting = append(greeting, nativePassword...)
+	greeting = append(greeting, 0)
+	if err := c.pc.writePacket(greeting); err != nil {
+		return 0, "", err
+	}
+	if err := c.pc.flush(); err != nil {
+		return 0, "", err
+	}
+
+	payload, err := c.pc.readPacket()
+	if err != nil {
+		return 0, "", err
+	}
+	r := &reader{buf: payload}
+	caps := r.uint32()
+	if caps&clientProtocol41 == 0 {
+		return 0, "", fmt.Errorf("client does not speak protocol 4.1")
+	}
+	caps &= serverCapabilities
+	r.bytes(4 + 1 + 23) // max packet size, charset, filler
+	user := r.nulString()
+	var authResp []byte
+	switch {
+	case caps&clientPluginAuthLenEncClientData != 0:
+		authResp = r.lenEncBytes()
+	case caps&clientSecureConnection != 0:
+		authResp = r.bytes(int(r.uint8()))
+	default:
+		authResp = []byte(r.nulString())
+	}
+	var db string
+	if caps&clientConnectWithDB != 0 {
+		db = r.nulString()
+	}
+	plugin := nativePassword
+	if caps&clientPluginAuth != 0 {
+		if p := r.nulString(); p != "" {
+			plugin = p
+		}
+	}
+	if r.err != nil {
+		return 0, "", r.err
+	}
+
+	if plugin != nativePassword {
+		// ask the client to answer the scramble the way we check it
+		switchReq := append([]byte{0xfe}, nativePassword...)
+		switchReq = append(switchReq, 0)
+		switchReq = append(switchReq, scramble...)
+		switchReq = append(switchReq, 0)
+		if err := c.pc.writePacket(switchReq); err != nil {
+			return 0, "", err
+		}
+		if err := c.pc.flush(); err != nil {
+			return 0, "", err
+		}
+		if authResp, err = c.pc.readPacket(); err != nil {
+			return 0, "", err
+		}
+	}
+	if !c.srv.authenticate(user, scramble, authResp) {
+		c.writeError(errAccessDenied(user))
+		return 0, "", errAuthFailed
+	}
+	return caps, db, nil
+}
+
+// authenticate checks the mysql_native_password answer of a client. A
+// server without a user accepts everyone.
+func (s *Server) authenticate(user string, scramble, authResp []byte) bool {
+	if s.opts.User == "" {
+		return true
+	}
+	if user != s.opts.User {
+		return false
+	}
+	if s.opts.Password == "" {
+		return len(authResp) == 0
+	}
+	return subtle.ConstantTimeCompare(authResp, scramblePassword(scramble, s.opts.Password)) == 1
+}
+
+// scramblePassword computes SHA1(password) XOR SHA1(scramble +
+// SHA1(SHA1(password))), the answer of mysql_native_password
+func scramblePassword(scramble []byte, password string) []byte {
+	stage1 := sha1.Sum([]byte(password))
+	stage2 := sha1.Sum(stage1[:])
+	h := sha1.New()
+	h.Write(bytes.TrimRight(scramble, "\x00"))
+	h.Write(stage2[:])
+	out := h.Sum(nil)
+	for i := range out {
+		out[i] ^= stage1[i]
+	}
+	return out
+}
@@ -0,0 +1,182 @@
+package mysql
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// maxPayload is the largest payload of one packet, longer payloads are
+// split over several packets
+const maxPayload = 1<<24 - 1
+
+var (
+	errMalformed      = errors.New("malformed packet")
+	errPacketTooLarge = errors.New("packet larger than the maximum payload size")
+)
+
+// packetConn reads and writes the packets of one client connection. seq
+// is the sequence number of the next packet, reset at every command.
+type packetConn struct {
+	r   *bufio.Reader
+	w   *bufio.Writer
+	seq byte
+	// maxSize bounds the payloads readPacket accepts
+	maxSize int
+}
+
+// readPacket reads the payload of the next packet, joining the packets of
+// a payload longer than maxPayload. A payload over maxSize fails with
+// errPacketTooLarge before it is read.
+func (pc *packetConn) readPacket() ([]byte, error) {
+	var payload []byte
+	for {
+		var header [4]byte
+		if _, err := io.ReadFull(pc.r, header[:]); err != nil {
+			return nil, err
+		}
+		n := int(header[0]) | int(header[1])<<8 | int(header[2])<<16
+		if header[3] != pc.seq {
+			return nil, fmt.Errorf("packet out of order: got %d, want %d", header[3], pc.seq)
+		}
+		pc.seq++
+		if len(payload)+n > pc.maxSize {
+			return nil, errPacketTooLarge
+		}
+		start := len(payload)
+		payload = append(payload, make([]byte, n)...)
+		if _, err := io.ReadFull(pc.r, payload[start:]); err != nil {
+			return nil, err
+		}
+		if n < maxPayload {
+			return payload, nil
+		}
+	}
+}
+
+// writePacket buffers payload as one or more packets, call flush to send
+// them
+func (pc *packetConn) writePacket(payload []byte) error {
+	for {
+		n := min(len(payload), maxPayload)
+		header := [4]byte{byte(n), byte(n >> 8), byte(n >> 16), pc.seq}
+		pc.seq++
+		if _, err := pc.w.Write(header[:]); err != nil {
+			return err
+		}
+		if _, err := pc.w.Write(payload[:n]); err != nil {
+			return err
+		}
+		payload = payload[n:]
+		// a payload of exactly maxPayload bytes ends with an empty packet
+		if n < maxPayload {
+			return nil
+		}
+	}
+}
+
+func (pc *packetConn) flush() error {
+	return pc.w.Flush()
+}
+
+func appendLenEncInt(b []byte, n uint64) []byte {
+	switch {
+	case n < 251:
+		return append(b, byte(n))
+	case n < 1<<16:
+		return append(b, 0xfc, byte(n), byte(n>>8))
+	case n < 1<<24:
+		return append(b, 0xfd, byte(n), byte(n>>8), byte(n>>16))
+	default:
+		b = append(b, 0xfe)
+		return binary.LittleEndian.AppendUint64(b, n)
+	}
+}
+
+func appendLenEncString(b []byte, s string) []byte {
+	b = appendLenEncInt(b, uint64(len(s)))
+	return append(b, s...)
+}
+
+// reader decodes the fields of a client packet. The first decoding error
+// sticks, check err once all fields are read.
+type reader struct {
+	buf []byte
+	err error
+}
+
+func (r *reader) bytes(n int) []byte {
+	if r.err != nil {
+		return nil
+	}
+	if n > len(r.buf) {
+		r.err = errMalformed
+		return nil
+	}
+	b := r.buf[:n]
+	r.buf = r.buf[n:]
+	return b
+}
+
+func (r *reader) uint8() byte {
+	if b := r.bytes(1); b != nil {
+		return b[0]
+	}
+	return 0
+}
+
+func (r *reader) uint32() uint32 {
+	if b := r.bytes(4); b != nil {
+		return binary.LittleEndian.Uint32(b)
+	}
+	return 0
+}
+
+func (r *reader) lenEncInt() uint64 {
+	switch first := r.uint8(); first {
+	case 0xfc:
+		if b := r.bytes(2); b != nil {
+			return uint64(binary.LittleEndian.Uint16(b))
+		}
+	case 0xfd:
+		if b := r.bytes(3); b != nil {
+			return uint64(b[0]) | uint64(b[1])<<8 | uint64(b[2])<<16
+		}
+	case 0xfe:
+		if b := r.bytes(8); b != nil {
+			return binary.LittleEndian.Uint64(b)
+		}
+	default:
+		return uint64(first)
+	}
+	return 0
+}
+
+func (r *reader) lenEncBytes() []byte {
+	n := r.lenEncInt()
+	if n > uint64(len(r.buf)) {
+		r.err = errMalformed
+		return nil
+	}
+	return r.bytes(int(n))
+}
+
+// nulString reads a string terminated by a NUL byte, or running to the
+// end of the packet
+func (r *reader) nulString() string {
+	if r.err != nil {
+		return ""
+	}
+	for i, c := range r.buf {
+		if c == 0 {
+			s := string(r.buf[:i])
+			r.buf = r.buf[i+1:]
+			return s
+		}
+	}
+	s := string(r.buf)
+	r.buf = nil
+	return s
+}
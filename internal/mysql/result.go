@@ -0,0 +1,124 @@
+package mysql
+
+import (
+	"encoding/binary"
+	"fmt"
+	"rflite/internal/executer"
+	"strconv"
+	"strings"
+)
+
+// Column types of the protocol
+const (
+	typeDouble    = 0x05
+	typeLongLong  = 0x08
+	typeBlob      = 0xfc
+	typeVarString = 0xfd
+)
+
+const (
+	flagBinary = 0x0080
+	// notFixedDecimals marks a floating point column
+	notFixedDecimals = 0x1f
+)
+
+// writeResultSet sends result as a text result set: the column count,
+// the column definitions, the rows and an EOF carrying status
+func (c *conn) writeResultSet(result *executer.Result, status uint16) error {
+	if err := c.pc.writePacket(appendLenEncInt(nil, uint64(len(result.Columns)))); err != nil {
+		return err
+	}
+	for i, name := range result.Columns {
+		typ := columnType(result.Types[i], result.Values, i)
+		def := appendLenEncString(nil, "def")
+		def = appendLenEncString(def, c.db)
+		def = appendLenEncString(def, "")
+		def = appendLenEncString(def, "")
+		def = appendLenEncString(def, name)
+		def = appendLenEncString(def, name)
+		def = append(def, 0x0c)
+		charset, flags, decimals := uint16(charsetUTF8MB4), uint16(0), byte(0)
+		switch typ {
+		case typeBlob:
+			charset, flags = charsetBinary, flagBinary
+		case typeDouble:
+			decimals = notFixedDecimals
+		}
+		def = binary.LittleEndian.AppendUint16(def, charset)
+		def = binary.LittleEndian.AppendUint32(def, 1<<24-1)
+		def = append(def, typ)
+		def = binary.LittleEndian.AppendUint16(def, flags)
+		def = append(def, decimals, 0, 0)
+		if err := c.pc.writePacket(def); err != nil {
+			return err
+		}
+	}
+	if err := c.writeEOF(status); err != nil {
+		return err
+	}
+
+	for _, values := range result.Values {
+		var row []byte
+		for _, v := range values {
+			if v == nil {
+				row = append(row, 0xfb)
+				continue
+			}
+			row = appendLenEncString(row, textValue(v))
+		}
+		if err := c.pc.writePacket(row); err != nil {
+			return err
+		}
+	}
+	return c.writeEOF(status)
+}
+
+// columnType picks the protocol type of a column from its declared type,
+// following SQLite's affinity rules, or from its first value when the
+// declaration does not settle it
+func columnType(declared string, values [][]interface{}, i int) byte {
+	declared = strings.ToUpper(declared)
+	switch {
+	case strings.Contains(declared, "INT"):
+		return typeLongLong
+	case strings.Contains(declared, "CHAR"), strings.Contains(declared, "CLOB"), strings.Contains(declared, "TEXT"):
+		return typeVarString
+	case strings.Contains(declared, "REAL"), strings.Contains(declared, "FLOA"), strings.Contains(declared, "DOUB"):
+		return typeDouble
+	}
+	for _, row := range values {
+		switch row[i].(type) {
+		case nil:
+			continue
+		case int64:
+			return typeLongLong
+		case float64:
+			return typeDouble
+		case []byte:
+			return typeBlob
+		}
+		return typeVarString
+	}
+	return typeVarString
+}
+
+// textValue formats a value of the text protocol
+func textValue(v interface{}) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case []byte:
+		return string(v)
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case float64:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	case bool:
+		if v {
+			return "1"
+		}
+		return "0"
+	default:
+		return fmt.Sprint(v)
+	}
+}
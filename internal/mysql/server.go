@@ -0,0 +1,535 @@
+// Package mysql serves the databases of a node over the MySQL
+// client/server protocol. It speaks enough of it for the usual clients:
+// the handshake with mysql_native_password, COM_QUERY with text result
+// sets, COM_INIT_DB and COM_PING. Prepared statements are not supported,
+// Go clients need interpolateParams=true.
+package mysql
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"rflite/internal/executer"
+	"rflite/internal/raft"
+	"rflite/internal/sql"
+	"rflite/pkg"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// serverVersion is what clients see as the MySQL version
+const serverVersion = "8.0.32-rflite"
+
+// Commands of the client/server protocol
+const (
+	comQuit            = 0x01
+	comInitDB          = 0x02
+	comQuery           = 0x03
+	comPing            = 0x0e
+	comResetConnection = 0x1f
+)
+
+// Status flags of OK and EOF packets
+const (
+	statusAutocommit  = 0x0002
+	statusMoreResults = 0x0008
+)
+
+var errAuthFailed = errors.New("authentication failed")
+
+// Options configures a Server
+type Options struct {
+	// User and Password are the credentials clients log in with. Without
+	// a User every client is accepted.
+	User     string
+	Password string
+	// ReadTimeout bounds a query, 0 leaves it unbounded
+	ReadTimeout time.Duration
+	// WriteTimeout bounds the wait for a write to be applied, defaults
+	// to raft.DefaultApplyTimeout
+	WriteTimeout time.Duration
+	// HandshakeTimeout bounds the login of a client, defaults to 10s
+	HandshakeTimeout time.Duration
+	// MaxPacketSize bounds the commands clients send, defaults to 64MB
+	// like max_allowed_packet
+	MaxPacketSize int
+}
+
+const (
+	defaultHandshakeTimeout = 10 * time.Second
+	defaultMaxPacketSize    = 64 << 20
+	// handshakeMaxPacket bounds the login packet of a client that is not
+	// authenticated yet
+	handshakeMaxPacket = 64 << 10
+)
+
+// Server answers MySQL clients. Reads run on the Executer of the current
+// database, statements that are not read-only are applied through its
+// Raft group, so writes only succeed on the leader.
+type Server struct {
+	m       *raft.DBManager
+	readers *executer.Registry
+	opts    Options
+	nextID  atomic.Uint32
+
+	mu       sync.Mutex
+	listener net.Listener
+	conns    map[*conn]struct{}
+	closed   bool
+}
+
+// NewServer creates a server reading through readers and writing through
+// m
+func NewServer(m *raft.DBManager, readers *executer.Registry, opts Options) *Server {
+	if opts.WriteTimeout <= 0 {
+		opts.WriteTimeout = raft.DefaultApplyTimeout
+	}
+	if opts.HandshakeTimeout <= 0 {
+		opts.HandshakeTimeout = defaultHandshakeTimeout
+	}
+	if opts.MaxPacketSize <= 0 {
+		opts.MaxPacketSize = defaultMaxPacketSize
+	}
+	return &Server{
+		m:       m,
+		readers: readers,
+		opts:    opts,
+		conns:   make(map[*conn]struct{}),
+	}
+}
+
+// Serve accepts clients on l until Close is called
+func (s *Server) Serve(l net.Listener) error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return net.ErrClosed
+	}
+	s.listener = l
+	s.mu.Unlock()
+
+	for {
+		nc, err := l.Accept()
+		if err != nil {
+			s.mu.Lock()
+			closed := s.closed
+			s.mu.Unlock()
+			if closed {
+				return nil
+			}
+			return err
+		}
+		c := &conn{
+			srv: s,
+			nc:  nc,
+			pc:  &packetConn{r: bufio.NewReader(nc), w: bufio.NewWriter(nc), maxSize: handshakeMaxPacket},
+			id:  s.nextID.Add(1),
+		}
+		s.mu.Lock()
+		if s.closed {
+			s.mu.Unlock()
+			nc.Close()
+			return nil
+		}
+		s.conns[c] = struct{}{}
+		s.mu.Unlock()
+		go c.serve()
+	}
+}
+
+// Close stops accepting clients and closes the open connections
+func (s *Server) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	for c := range s.conns {
+		c.nc.Close()
+	}
+	if s.listener == nil {
+		return nil
+	}
+	return s.listener.Close()
+}
+
+// conn is one client connection
+type conn struct {
+	srv *Server
+	nc  net.Conn
+	pc  *packetConn
+	id  uint32
+	// caps are the capabilities shared with the client
+	caps uint32
+	// db is the current database, set by USE or COM_INIT_DB
+	db string
+}
+
+func (c *conn) serve() {
+	defer func() {
+		c.nc.Close()
+		c.srv.mu.Lock()
+		delete(c.srv.conns, c)
+		c.srv.mu.Unlock()
+	}()
+
+	// a client that does not log in in time must not hold the connection
+	c.nc.SetDeadline(time.Now().Add(c.srv.opts.HandshakeTimeout))
+	caps, db, err := c.handshake()
+	if err != nil {
+		if !errors.Is(err, errAuthFailed) {
+			log.Printf("mysql: handshake with %s failed: %v", c.nc.RemoteAddr(), err)
+		}
+		return
+	}
+	if err := c.nc.SetDeadline(time.Time{}); err != nil {
+		return
+	}
+	c.pc.maxSize = c.srv.opts.MaxPacketSize
+	c.caps = caps
+	if db != "" {
+		if !c.srv.m.HasDatabase(db) {
+			c.writeError(errBadDB(db))
+			return
+		}
+		c.db = db
+	}
+	if err := c.writeOK(0, 0, statusAutocommit); err != nil {
+		return
+	}
+
+	for {
+		c.pc.seq = 0
+		payload, err := c.pc.readPacket()
+		if errors.Is(err, errPacketTooLarge) {
+			c.writeError(&sqlError{code: 1153, state: "08S01", msg: fmt.Sprintf("got a packet bigger than %d bytes", c.pc.maxSize)})
+			return
+		}
+		if err != nil {
+			return
+		}
+		if len(payload) == 0 {
+			return
+		}
+		if payload[0] == comQuit {
+			return
+		}
+		if err := c.dispatch(payload[0], payload[1:]); err != nil {
+			log.Printf("mysql: connection %d: %v", c.id, err)
+			return
+		}
+	}
+}
+
+// dispatch runs one command. Errors of the command are sent to the
+// client, the returned error is a broken connection.
+func (c *conn) dispatch(cmd byte, data []byte) error {
+	switch cmd {
+	case comPing, comResetConnection:
+		return c.writeOK(0, 0, statusAutocommit)
+	case comInitDB:
+		db := string(data)
+		if !c.srv.m.HasDatabase(db) {
+			return c.writeError(errBadDB(db))
+		}
+		c.db = db
+		return c.writeOK(0, 0, statusAutocommit)
+	case comQuery:
+		return c.query(string(data))
+	default:
+		return c.writeError(&sqlError{code: 1047, state: "08S01", msg: fmt.Sprintf("Unknown command %#x", cmd)})
+	}
+}
+
+// query runs the statements of a COM_QUERY, each with its own result.
+// The first failed statement ends the query.
+func (c *conn) query(text string) error {
+	stmts, err := pkg.SplitStatements(text)
+	if err != nil {
+		return c.writeError(errParse(err))
+	}
+	if len(stmts) == 0 {
+		return c.writeError(&sqlError{code: 1065, state: "42000", msg: "Query was empty"})
+	}
+	if len(stmts) > 1 && c.caps&clientMultiStatements == 0 {
+		return c.writeError(errParse(errors.New("several statements need CLIENT_MULTI_STATEMENTS")))
+	}
+	for i, stmt := range stmts {
+		status := uint16(statusAutocommit)
+		if i < len(stmts)-1 {
+			status |= statusMoreResults
+		}
+		err := c.statement(stmt, status)
+		var sqlErr *sqlError
+		if errors.As(err, &sqlErr) {
+			return c.writeError(sqlErr)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return c.pc.flush()
+}
+
+// statement answers one statement. Its *sqlError is sent by the caller,
+// before anything else was written for the statement.
+func (c *conn) statement(stmt pkg.Statement, status uint16) error {
+	tokens := stmt.Tokens()
+	if db, ok, err := stmt.UseTarget(); ok {
+		if err != nil {
+			return errParse(err)
+		}
+		if !c.srv.m.HasDatabase(db) {
+			return errBadDB(db)
+		}
+		c.db = db
+		return c.writeOK(0, 0, status)
+	}
+	switch {
+	case tokens[0].Is("SET"):
+		// session settings such as SET NAMES have nothing to change
+		return c.writeOK(0, 0, status)
+	case tokens[0].Is("BEGIN"), tokens[0].Is("START"), tokens[0].Is("COMMIT"), tokens[0].Is("ROLLBACK"):
+		// a ROLLBACK answered with OK would claim to undo writes that
+		// already committed on their own
+		return &sqlError{code: 1235, state: "42000", msg: "transactions are not supported, every statement commits on its own"}
+	case tokens[0].Is("SHOW"):
+		return c.show(tokens, status)
+	}
+	if columns, row, ok, err := c.sysVars(tokens); ok {
+		if err != nil {
+			return err
+		}
+		return c.writeResultSet(&executer.Result{Columns: columns, Types: make([]string, len(columns)), Values: [][]interface{}{row}}, status)
+	}
+
+	if c.db == "" {
+		return &sqlError{code: 1046, state: "3D000", msg: "No database selected"}
+	}
+	result, err := c.read(stmt.SQL)
+	if errors.Is(err, executer.ErrNotReadOnly) {
+		return c.write(stmt.SQL, status)
+	}
+	if err != nil {
+		return err
+	}
+	return c.writeResultSet(result, status)
+}
+
+// read runs a query on the Executer of the current database
+func (c *conn) read(sqlStr string) (*executer.Result, error) {
+	exec, err := c.srv.readers.Get(c.db)
+	if err != nil {
+		return nil, errUnknown(err)
+	}
+	ctx := context.Background()
+	if c.srv.opts.ReadTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.srv.opts.ReadTimeout)
+		defer cancel()
+	}
+	result, err := exec.ExecQuery(ctx, sqlStr)
+	switch {
+	case err == nil, errors.Is(err, executer.ErrNotReadOnly):
+		return result, err
+	case errors.Is(err, executer.ErrTimeout):
+		return nil, &sqlError{code: 3024, state: "HY000", msg: err.Error()}
+	default:
+		return nil, errUnknown(err)
+	}
+}
+
+// write applies a statement through the Raft group of the current
+// database
+func (c *conn) write(sqlStr string, status uint16) error {
+	ctx, cancel := context.WithTimeout(context.Background(), c.srv.opts.WriteTimeout)
+	defer cancel()
+	result, err := c.srv.m.ApplyCommandContext(ctx, c.db, raft.Command{SQL: sqlStr})
+	var syntaxErr *pkg.SyntaxError
+	switch {
+	case errors.Is(err, raft.ErrNotLeader):
+		return &sqlError{code: 1290, state: "HY000", msg: fmt.Sprintf("this node is not the leader of %s, send writes to the leader", c.db)}
+	case errors.Is(err, raft.ErrApplyTimeout):
+		return &sqlError{code: 3024, state: "HY000", msg: err.Error()}
+	case errors.As(err, &syntaxErr):
+		return errParse(err)
+	case err != nil:
+		return errUnknown(err)
+	}
+	if !result.Committed {
+		return errStatement(result.Err())
+	}
+	res := result.Results[0]
+	return c.writeOK(uint64(res.RowsAffected), uint64(res.LastInsertID), status)
+}
+
+// show answers SHOW DATABASES and SHOW TABLES
+func (c *conn) show(tokens []pkg.Token, status uint16) error {
+	switch {
+	case len(tokens) == 2 && tokens[1].Is("DATABASES"):
+		result := &executer.Result{Columns: []string{"Database"}, Types: []string{"TEXT"}}
+		for _, db := range c.srv.m.Databases() {
+			result.Values = append(result.Values, []interface{}{db})
+		}
+		return c.writeResultSet(result, status)
+	case len(tokens) == 2 && tokens[1].Is("TABLES"):
+		if c.db == "" {
+			return &sqlError{code: 1046, state: "3D000", msg: "No database selected"}
+		}
+		result, err := c.read("SELECT name FROM sqlite_schema WHERE type = 'table' AND name NOT LIKE 'sqlite_%' ORDER BY name")
+		if err != nil {
+			return err
+		}
+		result.Columns = []string{"Tables_in_" + c.db}
+		return c.writeResultSet(result, status)
+	}
+	return &sqlError{code: 1235, state: "42000", msg: "only SHOW DATABASES and SHOW TABLES are supported"}
+}
+
+// sysVars answers the SELECT of system variables and DATABASE() that
+// clients send after connecting, such as SELECT @@version_comment LIMIT 1.
+// ok is false for any other statement.
+func (c *conn) sysVars(tokens []pkg.Token) (columns []string, row []interface{}, ok bool, err error) {
+	if !tokens[0].Is("SELECT") {
+		return nil, nil, false, nil
+	}
+	rest := tokens[1:]
+	for len(rest) > 0 {
+		var name string
+		var value interface{}
+		switch {
+		case len(rest) >= 3 && rest[0].Is("DATABASE") && rest[1].Text == "(" && rest[2].Text == ")":
+			name, rest = "DATABASE()", rest[3:]
+			if c.db != "" {
+				value = c.db
+			}
+		case len(rest) >= 2 && rest[0].Text == "@" && rest[1].Kind == pkg.TokenParam && rest[1].Text[0] == '@':
+			name, rest = "@"+rest[1].Text, rest[2:]
+			variable := strings.ToLower(name[2:])
+			// @@session.name and @@global.name
+			if (variable == "session" || variable == "global") && len(rest) >= 2 && rest[0].Text == "." {
+				name += "." + rest[1].Text
+				variable, rest = strings.ToLower(rest[1].Text), rest[2:]
+			}
+			v, known := systemVariables[variable]
+			if !known {
+				return nil, nil, true, &sqlError{code: 1193, state: "HY000", msg: fmt.Sprintf("Unknown system variable '%s'", variable)}
+			}
+			value = v
+		default:
+			return nil, nil, false, nil
+		}
+		columns = append(columns, name)
+		row = append(row, value)
+		if len(rest) > 0 && rest[0].Text == "," {
+			rest = rest[1:]
+			continue
+		}
+		if len(rest) == 2 && rest[0].Is("LIMIT") && rest[1].Kind == pkg.TokenNumber {
+			rest = nil
+		}
+		if len(rest) > 0 {
+			return nil, nil, false, nil
+		}
+	}
+	return columns, row, len(columns) > 0, nil
+}
+
+// systemVariables are the values of the system variables clients ask for
+var systemVariables = map[string]interface{}{
+	"version":                  serverVersion,
+	"version_comment":          "rflite",
+	"max_allowed_packet":       int64(64 << 20),
+	"autocommit":               int64(1),
+	"transaction_isolation":    "SERIALIZABLE",
+	"tx_isolation":             "SERIALIZABLE",
+	"character_set_client":     "utf8mb4",
+	"character_set_connection": "utf8mb4",
+	"character_set_results":    "utf8mb4",
+	"collation_connection":     "utf8mb4_general_ci",
+	"sql_mode":                 "",
+	"time_zone":                "SYSTEM",
+	"system_time_zone":         "UTC",
+	"lower_case_table_names":   int64(0),
+	"wait_timeout":             int64(28800),
+	"interactive_timeout":      int64(28800),
+}
+
+func (c *conn) writeOK(affected, insertID uint64, status uint16) error {
+	b := []byte{0x00}
+	b = appendLenEncInt(b, affected)
+	b = appendLenEncInt(b, insertID)
+	b = binary.LittleEndian.AppendUint16(b, status)
+	b = binary.LittleEndian.AppendUint16(b, 0)
+	if err := c.pc.writePacket(b); err != nil {
+		return err
+	}
+	if status&statusMoreResults != 0 {
+		return nil
+	}
+	return c.pc.flush()
+}
+
+func (c *conn) writeEOF(status uint16) error {
+	b := []byte{0xfe, 0, 0}
+	b = binary.LittleEndian.AppendUint16(b, status)
+	return c.pc.writePacket(b)
+}
+
+func (c *conn) writeError(e *sqlError) error {
+	b := []byte{0xff}
+	b = binary.LittleEndian.AppendUint16(b, e.code)
+	b = append(b, '#')
+	b = append(b, e.state...)
+	b = append(b, e.msg...)
+	if err := c.pc.writePacket(b); err != nil {
+		return err
+	}
+	return c.pc.flush()
+}
+
+// sqlError is an error sent to the client as an ERR packet
+type sqlError struct {
+	code  uint16
+	state string
+	msg   string
+}
+
+func (e *sqlError) Error() string {
+	return fmt.Sprintf("%d (%s): %s", e.code, e.state, e.msg)
+}
+
+func errAccessDenied(user string) *sqlError {
+	return &sqlError{code: 1045, state: "28000", msg: fmt.Sprintf("Access denied for user '%s'", user)}
+}
+
+func errBadDB(db string) *sqlError {
+	return &sqlError{code: 1049, state: "42000", msg: fmt.Sprintf("Unknown database '%s'", db)}
+}
+
+func errParse(err error) *sqlError {
+	return &sqlError{code: 1064, state: "42000", msg: err.Error()}
+}
+
+func errUnknown(err error) *sqlError {
+	return &sqlError{code: 1105, state: "HY000", msg: err.Error()}
+}
+
+// errStatement maps a failed statement to the MySQL error clients expect,
+// duplicate keys in particular
+func errStatement(err error) *sqlError {
+	var stmtErr *sql.StatementError
+	if errors.As(err, &stmtErr) {
+		switch stmtErr.Code {
+		case 1555, 2067: // SQLITE_CONSTRAINT_PRIMARYKEY, SQLITE_CONSTRAINT_UNIQUE
+			return &sqlError{code: 1062, state: "23000", msg: err.Error()}
+		case 1299: // SQLITE_CONSTRAINT_NOTNULL
+			return &sqlError{code: 1048, state: "23000", msg: err.Error()}
+		}
+	}
+	return errUnknown(err)
+}
@@ -0,0 +1,192 @@
+package mysql
+
+import (
+	gosql "database/sql"
+	"errors"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+
+	"rflite/internal/executer"
+	"rflite/internal/raft"
+)
+
+func newTestServer(t *testing.T, opts Options) string {
+	t.Helper()
+	m, err := raft.NewDBManagerWithOptions(raft.Options{
+		NodeID:    "node1",
+		BasePath:  filepath.Join(t.TempDir(), "node1"),
+		DBIDs:     []string{"db1", "db2"},
+		BindAddr:  "127.0.0.1:0",
+		Bootstrap: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { m.Shutdown() })
+	deadline := time.Now().Add(5 * time.Second)
+	for !m.AllLeadersOK() {
+		if time.Now().After(deadline) {
+			t.Fatal("no leader before timeout")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	readers := executer.NewRegistry(m.DBPath, executer.Options{})
+	t.Cleanup(func() { readers.Close() })
+	srv := NewServer(m, readers, opts)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go srv.Serve(l)
+	t.Cleanup(func() { srv.Close() })
+	return l.Addr().String()
+}
+
+func openClient(t *testing.T, dsn string) *gosql.DB {
+	t.Helper()
+	db, err := gosql.Open("mysql", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func TestServer(t *testing.T) {
+	addr := newTestServer(t, Options{})
+	db := openClient(t, "root@tcp("+addr+")/db1?interpolateParams=true&multiStatements=true")
+	db.SetMaxOpenConns(1)
+
+	if err := db.Ping(); err != nil {
+		t.Fatalf("Ping failed: %v", err)
+	}
+	if _, err := db.Exec("CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT, score REAL, avatar BLOB)"); err != nil {
+		t.Fatalf("CREATE TABLE failed: %v", err)
+	}
+	res, err := db.Exec("INSERT INTO users (name, score) VALUES (?, ?)", "Alice", 1.5)
+	if err != nil {
+		t.Fatalf("INSERT failed: %v", err)
+	}
+	if id, _ := res.LastInsertId(); id != 1 {
+		t.Fatalf("got insert id %d, want 1", id)
+	}
+	if _, err := db.Exec("INSERT INTO users (name, avatar) VALUES ('Bob;', x'0102')"); err != nil {
+		t.Fatalf("INSERT failed: %v", err)
+	}
+	_, err = db.Exec("INSERT INTO users (id, name) VALUES (1, 'again')")
+	var mysqlErr *mysql.MySQLError
+	if !errors.As(err, &mysqlErr) || mysqlErr.Number != 1062 {
+		t.Fatalf("got %v, want a duplicate key error", err)
+	}
+
+	rows, err := db.Query("SELECT id, name, score, avatar FROM users ORDER BY id")
+	if err != nil {
+		t.Fatalf("SELECT failed: %v", err)
+	}
+	types, _ := rows.ColumnTypes()
+	if got := types[0].DatabaseTypeName(); got != "BIGINT" {
+		t.Errorf("got id type %s, want BIGINT", got)
+	}
+	var got []string
+	for rows.Next() {
+		var id int64
+		var name string
+		var score gosql.NullFloat64
+		var avatar []byte
+		if err := rows.Scan(&id, &name, &score, &avatar); err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, name)
+		if id == 1 && score.Float64 != 1.5 {
+			t.Errorf("got score %v, want 1.5", score)
+		}
+		if id == 2 && (score.Valid || string(avatar) != "\x01\x02") {
+			t.Errorf("got score %v and avatar %x", score, avatar)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+	rows.Close()
+	if len(got) != 2 || got[0] != "Alice" || got[1] != "Bob;" {
+		t.Fatalf("got %q", got)
+	}
+
+	// USE switches the database of the connection
+	var name string
+	if err := db.QueryRow("USE db2; SELECT DATABASE()").Scan(&name); err != nil || name != "db2" {
+		t.Fatalf("got %q, %v", name, err)
+	}
+	if _, err := db.Exec("SELECT * FROM users"); err == nil {
+		t.Fatal("expected users to be missing from db2")
+	}
+	var version string
+	if err := db.QueryRow("SELECT @@version_comment LIMIT 1").Scan(&version); err != nil || version != "rflite" {
+		t.Fatalf("got %q, %v", version, err)
+	}
+	if _, err := db.Exec("USE missing"); !errors.As(err, &mysqlErr) || mysqlErr.Number != 1049 {
+		t.Fatalf("got %v, want an unknown database error", err)
+	}
+	// every statement commits on its own, nothing can be rolled back
+	for _, q := range []string{"BEGIN", "COMMIT", "ROLLBACK"} {
+		if _, err := db.Exec(q); !errors.As(err, &mysqlErr) || mysqlErr.Number != 1235 {
+			t.Fatalf("%s: got %v, want a not supported error", q, err)
+		}
+	}
+
+	// COM_INIT_DB through the DSN
+	other := openClient(t, "root@tcp("+addr+")/missing")
+	if err := other.Ping(); !errors.As(err, &mysqlErr) || mysqlErr.Number != 1049 {
+		t.Fatalf("got %v, want an unknown database error", err)
+	}
+}
+
+func TestServerLimits(t *testing.T) {
+	addr := newTestServer(t, Options{HandshakeTimeout: 100 * time.Millisecond, MaxPacketSize: 1024})
+
+	// a client that never logs in is disconnected
+	nc, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nc.Close()
+	nc.SetReadDeadline(time.Now().Add(5 * time.Second))
+	buf := make([]byte, 4096)
+	for {
+		if _, err := nc.Read(buf); err != nil {
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				t.Fatal("connection still open after the handshake timeout")
+			}
+			break
+		}
+	}
+
+	db := openClient(t, "root@tcp("+addr+")/db1")
+	if err := db.Ping(); err != nil {
+		t.Fatalf("Ping failed: %v", err)
+	}
+	_, err = db.Exec("SELECT '" + strings.Repeat("x", 2048) + "'")
+	var mysqlErr *mysql.MySQLError
+	if !errors.As(err, &mysqlErr) || mysqlErr.Number != 1153 {
+		t.Fatalf("got %v, want a packet too large error", err)
+	}
+}
+
+func TestServerAuth(t *testing.T) {
+	addr := newTestServer(t, Options{User: "app", Password: "secret"})
+	if err := openClient(t, "app:secret@tcp("+addr+")/db1").Ping(); err != nil {
+		t.Fatalf("Ping failed: %v", err)
+	}
+	var mysqlErr *mysql.MySQLError
+	err := openClient(t, "app:wrong@tcp("+addr+")/db1").Ping()
+	if !errors.As(err, &mysqlErr) || mysqlErr.Number != 1045 {
+		t.Fatalf("got %v, want access denied", err)
+	}
+}
@@ -0,0 +1,401 @@
+package raft
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/raft"
+)
+
+// CatalogID is the Raft group replicating the set of databases. Database
+// names must start with a letter, so it never collides with one.
+const CatalogID = "_catalog"
+
+var (
+	ErrDBExists      = errors.New("database already exists")
+	ErrInvalidDBName = errors.New("invalid database name")
+
+	validDBID = regexp.MustCompile(`^[a-zA-Z][a-zA-Z0-9_]*$`)
+)
+
+// catalogCommand is a replicated change to the set of databases
+type catalogCommand struct {
+	Op string `json:"op"`
+	DB string `json:"db"`
+	// Servers bootstraps the new group. Every replica bootstraps it with
+	// the same configuration, so they agree on its first log entry.
+	Servers []raft.Server `json:"servers,omitempty"`
+}
+
+// catalogEntry is what the catalog remembers about a database
+type catalogEntry struct {
+	// Index is the catalog log index that created the database
+	Index   uint64        `json:"index"`
+	Servers []raft.Server `json:"servers"`
+}
+
+// catalogState is the snapshot of a CatalogFSM
+type catalogState struct {
+	Databases map[string]catalogEntry `json:"databases"`
+	// Dropped maps dropped databases to the index of the drop, so a node
+	// that still has one removes it again after a restore.
+	Dropped map[string]uint64 `json:"dropped"`
+}
+
+// CatalogFSM tracks the databases created at runtime. Applying a create
+// starts the database's Raft group on this node, applying a drop tears it
+// down. Both callbacks get the log index of the change, because Raft
+// replays the log after a restart and an old entry must not touch a
+// database re-created later under the same name.
+type CatalogFSM struct {
+	mu        sync.Mutex
+	databases map[string]catalogEntry
+	dropped   map[string]uint64
+	onCreate  func(dbID string, index uint64, servers []raft.Server) error
+	onDrop    func(dbID string, index uint64) error
+}
+
+func NewCatalogFSM(onCreate func(dbID string, index uint64, servers []raft.Server) error, onDrop func(dbID string, index uint64) error) *CatalogFSM {
+	return &CatalogFSM{
+		databases: make(map[string]catalogEntry),
+		dropped:   make(map[string]uint64),
+		onCreate:  onCreate,
+		onDrop:    onDrop,
+	}
+}
+
+func (f *CatalogFSM) Apply(l *raft.Log) interface{} {
+	var cmd catalogCommand
+	if err := json.Unmarshal(l.Data, &cmd); err != nil {
+		log.Printf("failed to unmarshal catalog command: %v", err)
+		return err
+	}
+
+	switch cmd.Op {
+	case "create":
+		f.mu.Lock()
+		_, ok := f.databases[cmd.DB]
+		if !ok {
+			f.databases[cmd.DB] = catalogEntry{Index: l.Index, Servers: cmd.Servers}
+			delete(f.dropped, cmd.DB)
+		}
+		f.mu.Unlock()
+		if ok {
+			return fmt.Errorf("DB %s: %w", cmd.DB, ErrDBExists)
+		}
+		if err := f.onCreate(cmd.DB, l.Index, cmd.Servers); err != nil {
+			log.Printf("failed to start DB %s: %v", cmd.DB, err)
+			return err
+		}
+		return nil
+	case "drop":
+		f.mu.Lock()
+		delete(f.databases, cmd.DB)
+		f.dropped[cmd.DB] = l.Index
+		f.mu.Unlock()
+		if err := f.onDrop(cmd.DB, l.Index); err != nil {
+			log.Printf("failed to drop DB %s: %v", cmd.DB, err)
+			return err
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown catalog op %q", cmd.Op)
+	}
+}
+
+// Databases returns the names of all databases in the catalog
+func (f *CatalogFSM) Databases() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	names := make([]string, 0, len(f.databases))
+	for name := range f.databases {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (f *CatalogFSM) Snapshot() (raft.FSMSnapshot, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	data, err := json.Marshal(catalogState{Databases: f.databases, Dropped: f.dropped})
+	if err != nil {
+		return nil, err
+	}
+	return &catalogSnapshot{data: data}, nil
+}
+
+func (f *CatalogFSM) Restore(rc io.ReadCloser) error {
+	defer rc.Close()
+	var state catalogState
+	if err := json.NewDecoder(rc).Decode(&state); err != nil {
+		return err
+	}
+	if state.Databases == nil {
+		state.Databases = make(map[string]catalogEntry)
+	}
+	if state.Dropped == nil {
+		state.Dropped = make(map[string]uint64)
+	}
+
+	f.mu.Lock()
+	f.databases = state.Databases
+	f.dropped = state.Dropped
+	f.mu.Unlock()
+	for name, index := range state.Dropped {
+		if err := f.onDrop(name, index); err != nil {
+			log.Printf("failed to drop DB %s: %v", name, err)
+		}
+	}
+	for name, entry := range state.Databases {
+		if err := f.onCreate(name, entry.Index, entry.Servers); err != nil {
+			log.Printf("failed to start DB %s: %v", name, err)
+		}
+	}
+	return nil
+}
+
+type catalogSnapshot struct {
+	data []byte
+}
+
+func (s *catalogSnapshot) Persist(sink raft.SnapshotSink) error {
+	if _, err := sink.Write(s.data); err != nil {
+		sink.Cancel()
+		return err
+	}
+	return sink.Close()
+}
+
+func (s *catalogSnapshot) Release() {}
+
+// startCatalog starts the catalog group, bootstrapping it with servers
+// when given
+func (m *DBManager) startCatalog(servers []raft.Server) error {
+	fsm := NewCatalogFSM(m.ensureGroup, m.dropGroup)
+	r, g, err := m.newRaftNode(CatalogID, fsm, servers)
+	if err != nil {
+		return err
+	}
+	m.catalog = r
+	m.catalogFSM = fsm
+	m.catalogGroup = g
+	return nil
+}
+
+// ensureGroup starts a database's group unless this node already hosts it
+// or its files belong to a later incarnation
+func (m *DBManager) ensureGroup(dbID string, index uint64, servers []raft.Server) error {
+	m.mu.RLock()
+	_, ok := m.Rafts[dbID]
+	closed := m.closed
+	m.mu.RUnlock()
+	if ok {
+		return nil
+	}
+	if closed {
+		return fmt.Errorf("DB manager is shut down")
+	}
+	current, err := m.incarnation(dbID)
+	if err != nil {
+		return err
+	}
+	if current > index {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Join(m.basePath, dbID), 0755); err != nil {
+		return err
+	}
+	if err := os.WriteFile(m.incarnationPath(dbID), []byte(strconv.FormatUint(index, 10)), 0644); err != nil {
+		return err
+	}
+	return m.startGroup(dbID, servers)
+}
+
+func (m *DBManager) incarnationPath(dbID string) string {
+	return filepath.Join(m.basePath, dbID, "incarnation")
+}
+
+// incarnation returns the catalog index that created the files of a
+// database on this node, 0 for a database from the static configuration
+func (m *DBManager) incarnation(dbID string) (uint64, error) {
+	data, err := os.ReadFile(m.incarnationPath(dbID))
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseUint(strings.TrimSpace(string(data)), 10, 64)
+}
+
+// CreateDatabase replicates the creation of a database through the
+// catalog group, so every node starts a Raft group for it. It must be
+// called on the catalog leader.
+func (m *DBManager) CreateDatabase(dbID string) error {
+	if !validDBID.MatchString(dbID) {
+		return fmt.Errorf("%w %q", ErrInvalidDBName, dbID)
+	}
+	m.mu.RLock()
+	_, ok := m.Rafts[dbID]
+	m.mu.RUnlock()
+	if ok {
+		return fmt.Errorf("DB %s: %w", dbID, ErrDBExists)
+	}
+	if m.catalog.State() != raft.Leader {
+		return fmt.Errorf("node %s is not catalog leader: %w", m.nodeID, ErrNotLeader)
+	}
+
+	future := m.catalog.GetConfiguration()
+	if err := future.Error(); err != nil {
+		return err
+	}
+	var servers []raft.Server
+	for _, srv := range future.Configuration().Servers {
+		if srv.Suffrage == raft.Voter {
+			servers = append(servers, srv)
+		}
+	}
+	data, err := json.Marshal(catalogCommand{Op: "create", DB: dbID, Servers: servers})
+	if err != nil {
+		return err
+	}
+	if err := m.applyCatalog(data); err != nil {
+		return err
+	}
+
+	// the group is running here once the command is applied, give it a
+	// moment to elect a leader so the first write does not fail
+	r, err := m.Raft(dbID)
+	if err != nil {
+		return err
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for r.Leader() == "" && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	return nil
+}
+
+// DropDatabase replicates the removal of a database through the catalog
+// group. Every node shuts down its Raft group and deletes its files. It
+// must be called on the catalog leader.
+func (m *DBManager) DropDatabase(dbID string) error {
+	if !m.HasDatabase(dbID) {
+		return fmt.Errorf("DB %s not found: %w", dbID, ErrDBNotFound)
+	}
+	if m.catalog.State() != raft.Leader {
+		return fmt.Errorf("node %s is not catalog leader: %w", m.nodeID, ErrNotLeader)
+	}
+	data, err := json.Marshal(catalogCommand{Op: "drop", DB: dbID})
+	if err != nil {
+		return err
+	}
+	return m.applyCatalog(data)
+}
+
+// dropGroup shuts down a database's Raft group and removes its SQLite
+// file, log, stable store and snapshots from this node, unless they
+// belong to an incarnation created after the drop at index
+func (m *DBManager) dropGroup(dbID string, index uint64) error {
+	current, err := m.incarnation(dbID)
+	if err != nil {
+		return err
+	}
+	if current > index {
+		return nil
+	}
+
+	m.mu.RLock()
+	r, ok := m.Rafts[dbID]
+	fsm := m.FSMs[dbID]
+	g := m.groups[dbID]
+	m.mu.RUnlock()
+
+	var firstErr error
+	if ok {
+		if err := r.Shutdown().Error(); err != nil {
+			firstErr = err
+		}
+		g.close()
+		if err := fsm.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	if err := os.RemoveAll(filepath.Join(m.basePath, dbID)); err != nil && firstErr == nil {
+		firstErr = err
+	}
+	if err := removeSQLiteFiles(m.DBPath(dbID)); err != nil && firstErr == nil {
+		firstErr = err
+	}
+
+	// unpublish the group once its files are gone, so HasDatabase only
+	// reports the drop when it is complete
+	m.mu.Lock()
+	delete(m.Rafts, dbID)
+	delete(m.FSMs, dbID)
+	delete(m.groups, dbID)
+	m.mu.Unlock()
+	m.reset(dbID)
+	log.Printf("Raft node %s dropped DB %s", m.nodeID, dbID)
+	return firstErr
+}
+
+func (m *DBManager) applyCatalog(data []byte) error {
+	future := m.catalog.Apply(data, 5*time.Second)
+	if err := future.Error(); err != nil {
+		if err == raft.ErrNotLeader || err == raft.ErrLeadershipLost {
+			return fmt.Errorf("node %s is not catalog leader: %w", m.nodeID, ErrNotLeader)
+		}
+		return err
+	}
+	if err, ok := future.Response().(error); ok {
+		return err
+	}
+	return nil
+}
+
+// Databases returns the names of all databases hosted on this node
+func (m *DBManager) Databases() []string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	names := make([]string, 0, len(m.Rafts))
+	for name := range m.Rafts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// HasDatabase reports whether this node hosts a database
+func (m *DBManager) HasDatabase(dbID string) bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	_, ok := m.Rafts[dbID]
+	return ok
+}
+
+// Raft returns the Raft node of a database group or the catalog
+func (m *DBManager) Raft(dbID string) (*raft.Raft, error) {
+	if dbID == CatalogID {
+		return m.catalog, nil
+	}
+	m.mu.RLock()
+	r, ok := m.Rafts[dbID]
+	m.mu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("DB %s not found: %w", dbID, ErrDBNotFound)
+	}
+	return r, nil
+}
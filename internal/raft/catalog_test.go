@@ -0,0 +1,161 @@
+package raft
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestCreateDatabaseReplicates(t *testing.T) {
+	base := t.TempDir()
+	node1 := newTestNode(t, base, "node1", nil, true)
+	node2 := newTestNode(t, base, "node2", nil, false)
+	waitFor(t, 5*time.Second, node1.AllLeadersOK)
+	for dbID, res := range node1.Join(node2.NodeID(), node2.RaftAddr()) {
+		if !res.Status {
+			t.Fatalf("join %s failed: %s", dbID, res.Error)
+		}
+	}
+
+	if err := node1.CreateDatabase("users"); err != nil {
+		t.Fatalf("CreateDatabase failed: %v", err)
+	}
+	if err := node1.CreateDatabase("users"); !errors.Is(err, ErrDBExists) {
+		t.Fatalf("expected ErrDBExists, got %v", err)
+	}
+	if err := node1.CreateDatabase("1bad"); !errors.Is(err, ErrInvalidDBName) {
+		t.Fatalf("expected ErrInvalidDBName, got %v", err)
+	}
+	if err := node2.CreateDatabase("other"); !errors.Is(err, ErrNotLeader) {
+		t.Fatalf("expected ErrNotLeader, got %v", err)
+	}
+
+	waitFor(t, 5*time.Second, func() bool { return node2.HasDatabase("users") })
+
+	// either node may win the first election of the new group
+	var leader *DBManager
+	waitFor(t, 5*time.Second, func() bool {
+		for _, node := range []*DBManager{node1, node2} {
+			if ok, _ := node.IsLeader("users"); ok {
+				leader = node
+				return true
+			}
+		}
+		return false
+	})
+	if _, err := leader.ApplyCommand("users", Command{SQL: "CREATE TABLE u (id INTEGER)"}); err != nil {
+		t.Fatalf("apply failed: %v", err)
+	}
+	node2.mu.RLock()
+	fsm := node2.FSMs["users"]
+	node2.mu.RUnlock()
+	waitFor(t, 5*time.Second, func() bool {
+		_, err := fsm.DB.Exec("SELECT * FROM u")
+		return err == nil
+	})
+}
+
+func TestCatalogRestartKeepsDatabases(t *testing.T) {
+	base := t.TempDir()
+	node := newTestNode(t, base, "node1", nil, true)
+	waitFor(t, 5*time.Second, node.AllLeadersOK)
+	if err := node.CreateDatabase("orders"); err != nil {
+		t.Fatalf("CreateDatabase failed: %v", err)
+	}
+	if err := node.Shutdown(); err != nil {
+		t.Fatalf("Shutdown failed: %v", err)
+	}
+
+	restarted := newTestNode(t, base, "node1", nil, true)
+	waitFor(t, 5*time.Second, func() bool { return restarted.HasDatabase("orders") })
+}
+
+func TestDropDatabaseReplicates(t *testing.T) {
+	base := t.TempDir()
+	node1 := newTestNode(t, base, "node1", []string{"static"}, true)
+	node2 := newTestNode(t, base, "node2", []string{"static"}, false)
+	waitFor(t, 5*time.Second, node1.AllLeadersOK)
+	for dbID, res := range node1.Join(node2.NodeID(), node2.RaftAddr()) {
+		if !res.Status {
+			t.Fatalf("join %s failed: %s", dbID, res.Error)
+		}
+	}
+	var resetMu sync.Mutex
+	reset := map[string]bool{}
+	node2.OnReset(func(dbID string) {
+		resetMu.Lock()
+		reset[dbID] = true
+		resetMu.Unlock()
+	})
+	if err := node1.CreateDatabase("users"); err != nil {
+		t.Fatalf("CreateDatabase failed: %v", err)
+	}
+	waitFor(t, 5*time.Second, func() bool { return node2.HasDatabase("users") })
+
+	for _, dbID := range []string{"users", "static"} {
+		if err := node1.DropDatabase(dbID); err != nil {
+			t.Fatalf("DropDatabase %s failed: %v", dbID, err)
+		}
+		for _, node := range []*DBManager{node1, node2} {
+			waitFor(t, 5*time.Second, func() bool { return !node.HasDatabase(dbID) })
+			if _, err := os.Stat(node.DBPath(dbID)); !os.IsNotExist(err) {
+				t.Fatalf("%s: expected %s to be removed, got %v", node.NodeID(), node.DBPath(dbID), err)
+			}
+			if _, err := os.Stat(filepath.Join(base, node.NodeID(), dbID)); !os.IsNotExist(err) {
+				t.Fatalf("%s: expected raft files of %s to be removed, got %v", node.NodeID(), dbID, err)
+			}
+		}
+	}
+	resetMu.Lock()
+	if !reset["users"] || !reset["static"] {
+		t.Fatalf("expected reset hooks for both drops, got %v", reset)
+	}
+	resetMu.Unlock()
+	if err := node1.DropDatabase("users"); !errors.Is(err, ErrDBNotFound) {
+		t.Fatalf("expected ErrDBNotFound, got %v", err)
+	}
+}
+
+func TestRecreatedDatabaseSurvivesRestart(t *testing.T) {
+	base := t.TempDir()
+	node := newTestNode(t, base, "node1", nil, true)
+	waitFor(t, 5*time.Second, node.AllLeadersOK)
+
+	if err := node.CreateDatabase("users"); err != nil {
+		t.Fatalf("CreateDatabase failed: %v", err)
+	}
+	if err := node.DropDatabase("users"); err != nil {
+		t.Fatalf("DropDatabase failed: %v", err)
+	}
+	if err := node.CreateDatabase("users"); err != nil {
+		t.Fatalf("CreateDatabase again failed: %v", err)
+	}
+	if _, err := node.ApplyCommand("users", Command{SQL: "CREATE TABLE u (id INTEGER)"}); err != nil {
+		t.Fatalf("apply failed: %v", err)
+	}
+	node.Shutdown()
+
+	// replaying the first create and the drop must leave the new data alone.
+	// AppliedIndex moves before the FSM ran the entry, so wait for the
+	// table itself.
+	restarted := newTestNode(t, base, "node1", nil, true)
+	var err error
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		restarted.mu.RLock()
+		fsm, ok := restarted.FSMs["users"]
+		restarted.mu.RUnlock()
+		if ok {
+			if _, err = fsm.DB.Exec("SELECT * FROM u"); err == nil {
+				break
+			}
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected table to survive restart: %v", err)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
@@ -0,0 +1,231 @@
+package raft
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"time"
+
+	"github.com/hashicorp/raft"
+)
+
+const (
+	// clusterServiceID is the mux header of node-to-node requests. It
+	// contains a character database names cannot, so it never collides
+	// with a Raft group.
+	clusterServiceID = ":cluster"
+	clusterTimeout   = 10 * time.Second
+)
+
+var ErrNoLeader = errors.New("no leader")
+
+// clusterRequest is sent by a node to another node's cluster service
+type clusterRequest struct {
+	Type     string `json:"type"`
+	DB       string `json:"db,omitempty"`
+	NodeID   string `json:"node_id,omitempty"`
+	RaftAddr string `json:"raft_addr,omitempty"`
+}
+
+type clusterResponse struct {
+	Error    string `json:"error,omitempty"`
+	HTTPAddr string `json:"http_addr,omitempty"`
+}
+
+// JoinRequest is sent by a new node to any cluster member
+type JoinRequest struct {
+	NodeID   string `json:"node_id"`
+	RaftAddr string `json:"raft_addr"`
+}
+
+// JoinResult reports whether a node was added to one database group
+type JoinResult struct {
+	Status bool   `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// startClusterService serves requests from other nodes on the mux
+func (m *DBManager) startClusterService() error {
+	layer, err := m.mux.register(clusterServiceID)
+	if err != nil {
+		return err
+	}
+	m.cluster = layer
+	go func() {
+		for {
+			conn, err := layer.Accept()
+			if err != nil {
+				return
+			}
+			go m.handleClusterConn(conn)
+		}
+	}()
+	return nil
+}
+
+func (m *DBManager) handleClusterConn(conn net.Conn) {
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(clusterTimeout))
+
+	var req clusterRequest
+	if err := json.NewDecoder(conn).Decode(&req); err != nil {
+		log.Printf("cluster service decode error: %v", err)
+		return
+	}
+
+	var resp clusterResponse
+	switch req.Type {
+	case "join":
+		if err := m.addVoter(req.DB, req.NodeID, req.RaftAddr); err != nil {
+			resp.Error = err.Error()
+		}
+	case "meta":
+		resp.HTTPAddr = m.httpAddr
+	default:
+		resp.Error = fmt.Sprintf("unknown request type %q", req.Type)
+	}
+	if err := json.NewEncoder(conn).Encode(resp); err != nil {
+		log.Printf("cluster service encode error: %v", err)
+	}
+}
+
+// clusterCall sends req to the cluster service of the node at addr
+func (m *DBManager) clusterCall(addr raft.ServerAddress, req clusterRequest) (*clusterResponse, error) {
+	conn, err := m.cluster.Dial(addr, clusterTimeout)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(clusterTimeout))
+
+	if err := json.NewEncoder(conn).Encode(req); err != nil {
+		return nil, err
+	}
+	var resp clusterResponse
+	if err := json.NewDecoder(conn).Decode(&resp); err != nil {
+		return nil, err
+	}
+	if resp.Error != "" {
+		return &resp, errors.New(resp.Error)
+	}
+	return &resp, nil
+}
+
+// Join adds the node nodeID, reachable at raftAddr, as a voter to the
+// catalog and every database group hosted here. Groups led by another node are forwarded
+// to their leader.
+func (m *DBManager) Join(nodeID, raftAddr string) map[string]JoinResult {
+	dbIDs := append([]string{CatalogID}, m.Databases()...)
+
+	results := make(map[string]JoinResult, len(dbIDs))
+	for _, dbID := range dbIDs {
+		if err := m.joinGroup(dbID, nodeID, raftAddr); err != nil {
+			results[dbID] = JoinResult{Status: false, Error: err.Error()}
+			continue
+		}
+		results[dbID] = JoinResult{Status: true}
+	}
+	return results
+}
+
+func (m *DBManager) joinGroup(dbID, nodeID, raftAddr string) error {
+	r, err := m.Raft(dbID)
+	if err != nil {
+		return err
+	}
+	if r.State() == raft.Leader {
+		return m.addVoter(dbID, nodeID, raftAddr)
+	}
+
+	leaderAddr, _ := r.LeaderWithID()
+	if leaderAddr == "" {
+		return fmt.Errorf("DB %s: %w", dbID, ErrNoLeader)
+	}
+	_, err = m.clusterCall(leaderAddr, clusterRequest{
+		Type:     "join",
+		DB:       dbID,
+		NodeID:   nodeID,
+		RaftAddr: raftAddr,
+	})
+	return err
+}
+
+// addVoter adds the node to a group this node leads. It never forwards,
+// so a stale leader answers with an error instead of bouncing requests.
+func (m *DBManager) addVoter(dbID, nodeID, raftAddr string) error {
+	if nodeID == "" || raftAddr == "" {
+		return fmt.Errorf("node id and raft address are required")
+	}
+	r, err := m.Raft(dbID)
+	if err != nil {
+		return err
+	}
+	if r.State() != raft.Leader {
+		return fmt.Errorf("node %s is not leader for DB %s", m.nodeID, dbID)
+	}
+
+	future := r.GetConfiguration()
+	if err := future.Error(); err != nil {
+		return err
+	}
+	for _, srv := range future.Configuration().Servers {
+		if srv.ID == raft.ServerID(nodeID) && srv.Address == raft.ServerAddress(raftAddr) {
+			// already a member, joining again is a no-op
+			return nil
+		}
+		if srv.ID == raft.ServerID(nodeID) || srv.Address == raft.ServerAddress(raftAddr) {
+			if err := r.RemoveServer(srv.ID, 0, 0).Error(); err != nil {
+				return fmt.Errorf("remove stale member %s: %w", srv.ID, err)
+			}
+		}
+	}
+	return r.AddVoter(raft.ServerID(nodeID), raft.ServerAddress(raftAddr), 0, 0).Error()
+}
+
+// LeaderHTTPAddr returns the HTTP API address of the leader of a DB's
+// Raft group, asking the leader over the mux the first time it is seen.
+func (m *DBManager) LeaderHTTPAddr(dbID string) (string, error) {
+	r, err := m.Raft(dbID)
+	if err != nil {
+		return "", err
+	}
+	leaderAddr, leaderID := r.LeaderWithID()
+	if leaderAddr == "" {
+		return "", fmt.Errorf("DB %s: %w", dbID, ErrNoLeader)
+	}
+	if leaderID == raft.ServerID(m.nodeID) {
+		return m.httpAddr, nil
+	}
+
+	m.httpAddrsMu.Lock()
+	addr, ok := m.httpAddrs[leaderAddr]
+	m.httpAddrsMu.Unlock()
+	if ok {
+		return addr, nil
+	}
+	resp, err := m.clusterCall(leaderAddr, clusterRequest{Type: "meta"})
+	if err != nil {
+		return "", err
+	}
+	if resp.HTTPAddr == "" {
+		return "", fmt.Errorf("leader %s has no HTTP address", leaderID)
+	}
+	m.httpAddrsMu.Lock()
+	m.httpAddrs[leaderAddr] = resp.HTTPAddr
+	m.httpAddrsMu.Unlock()
+	return resp.HTTPAddr, nil
+}
+
+// ForgetHTTPAddr drops a cached peer HTTP address, e.g. after a request
+// forwarded to it failed
+func (m *DBManager) ForgetHTTPAddr(httpAddr string) {
+	m.httpAddrsMu.Lock()
+	defer m.httpAddrsMu.Unlock()
+	for raftAddr, addr := range m.httpAddrs {
+		if addr == httpAddr {
+			delete(m.httpAddrs, raftAddr)
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package raft
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func newTestNode(t *testing.T, base, nodeID string, dbIDs []string, bootstrap bool) *DBManager {
+	t.Helper()
+	m, err := NewDBManagerWithOptions(Options{
+		NodeID:    nodeID,
+		BasePath:  filepath.Join(base, nodeID),
+		DBIDs:     dbIDs,
+		BindAddr:  "127.0.0.1:0",
+		Bootstrap: bootstrap,
+		HTTPAddr:  nodeID + ".local:8001",
+	})
+	if err != nil {
+		t.Fatalf("failed to create %s: %v", nodeID, err)
+	}
+	t.Cleanup(func() { m.Shutdown() })
+	return m
+}
+
+func TestJoinForwardsToLeader(t *testing.T) {
+	dbIDs := []string{"db1", "db2", "db3"}
+	base := t.TempDir()
+
+	node1 := newTestNode(t, base, "node1", dbIDs, true)
+	node2 := newTestNode(t, base, "node2", dbIDs, false)
+	node3 := newTestNode(t, base, "node3", dbIDs, false)
+	waitFor(t, 5*time.Second, node1.AllLeadersOK)
+
+	// node1 leads every group and adds node2 itself
+	for dbID, res := range node1.Join(node2.NodeID(), node2.RaftAddr()) {
+		if !res.Status {
+			t.Fatalf("join node2 to %s failed: %s", dbID, res.Error)
+		}
+	}
+	for _, dbID := range dbIDs {
+		waitFor(t, 5*time.Second, func() bool {
+			return node2.Rafts[dbID].Leader() != ""
+		})
+	}
+
+	// node2 is a follower and has to forward to node1
+	for dbID, res := range node2.Join(node3.NodeID(), node3.RaftAddr()) {
+		if !res.Status {
+			t.Fatalf("join node3 to %s failed: %s", dbID, res.Error)
+		}
+	}
+
+	for _, dbID := range dbIDs {
+		future := node1.Rafts[dbID].GetConfiguration()
+		if err := future.Error(); err != nil {
+			t.Fatal(err)
+		}
+		if n := len(future.Configuration().Servers); n != 3 {
+			t.Fatalf("expected 3 servers in %s, got %d", dbID, n)
+		}
+	}
+
+	// joining again is a no-op
+	for dbID, res := range node1.Join(node3.NodeID(), node3.RaftAddr()) {
+		if !res.Status {
+			t.Fatalf("rejoin node3 to %s failed: %s", dbID, res.Error)
+		}
+	}
+
+	for _, dbID := range dbIDs {
+		cmd := Command{SQL: fmt.Sprintf("CREATE TABLE %s_t (id INTEGER)", dbID)}
+		if _, err := node1.ApplyCommand(dbID, cmd); err != nil {
+			t.Fatalf("apply on %s failed: %v", dbID, err)
+		}
+		fsm := node3.FSMs[dbID]
+		waitFor(t, 5*time.Second, func() bool {
+			_, err := fsm.DB.Exec(fmt.Sprintf("SELECT * FROM %s_t", dbID))
+			return err == nil
+		})
+	}
+}
+
+func TestLeaderHTTPAddr(t *testing.T) {
+	dbIDs := []string{"db1"}
+	base := t.TempDir()
+
+	node1 := newTestNode(t, base, "node1", dbIDs, true)
+	node2 := newTestNode(t, base, "node2", dbIDs, false)
+	waitFor(t, 5*time.Second, node1.AllLeadersOK)
+	if res := node1.Join(node2.NodeID(), node2.RaftAddr())["db1"]; !res.Status {
+		t.Fatalf("join failed: %s", res.Error)
+	}
+	waitFor(t, 5*time.Second, func() bool {
+		return node2.Rafts["db1"].Leader() != ""
+	})
+
+	if leader, err := node2.IsLeader("db1"); err != nil || leader {
+		t.Fatalf("expected node2 to be a follower, got leader=%v err=%v", leader, err)
+	}
+	for _, m := range []*DBManager{node1, node2} {
+		addr, err := m.LeaderHTTPAddr("db1")
+		if err != nil {
+			t.Fatalf("LeaderHTTPAddr failed on %s: %v", m.NodeID(), err)
+		}
+		if addr != node1.HTTPAddr() {
+			t.Fatalf("expected %s, got %s", node1.HTTPAddr(), addr)
+		}
+	}
+
+	_, err := node2.ApplyCommand("db1", Command{SQL: "SELECT 1"})
+	if !errors.Is(err, ErrNotLeader) {
+		t.Fatalf("expected ErrNotLeader, got %v", err)
+	}
+	if _, err := node2.IsLeader("missing"); !errors.Is(err, ErrDBNotFound) {
+		t.Fatalf("expected ErrDBNotFound, got %v", err)
+	}
+}
@@ -0,0 +1,75 @@
+package raft
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/hashicorp/raft"
+)
+
+// ReadLevel is the consistency a read asks for
+type ReadLevel string
+
+const (
+	// LevelNone reads the local SQLite file, however stale it is. Right
+	// after a restart the file is rebuilt from Raft and may be empty.
+	LevelNone ReadLevel = "none"
+	// LevelWeak reads on a node that believes it leads the group. A
+	// deposed leader may still serve a stale read until it notices.
+	LevelWeak ReadLevel = "weak"
+	// LevelStrong confirms leadership with a quorum and waits for every
+	// committed entry to be applied before reading
+	LevelStrong ReadLevel = "strong"
+)
+
+var ErrInvalidLevel = errors.New("invalid read consistency level")
+
+// ParseReadLevel parses the level of a read, LevelNone when empty
+func ParseReadLevel(s string) (ReadLevel, error) {
+	switch level := ReadLevel(s); level {
+	case "":
+		return LevelNone, nil
+	case LevelNone, LevelWeak, LevelStrong:
+		return level, nil
+	default:
+		return "", fmt.Errorf("%w %q", ErrInvalidLevel, s)
+	}
+}
+
+// VerifyRead checks that this node may serve a read of a DB at level. It
+// returns ErrNotLeader when the level needs the leader and this node is
+// not, or no longer, leading the group.
+func (m *DBManager) VerifyRead(dbID string, level ReadLevel) error {
+	r, err := m.Raft(dbID)
+	if err != nil {
+		return err
+	}
+	switch level {
+	case LevelNone:
+		return nil
+	case LevelWeak:
+		if r.State() != raft.Leader {
+			return fmt.Errorf("node %s is not leader: %w", dbID, ErrNotLeader)
+		}
+		return nil
+	case LevelStrong:
+		if err := r.VerifyLeader().Error(); err != nil {
+			if err == raft.ErrNotLeader || err == raft.ErrLeadershipLost {
+				return fmt.Errorf("node %s is not leader: %w", dbID, ErrNotLeader)
+			}
+			return err
+		}
+		// a new leader may not have applied everything its predecessor
+		// committed yet
+		if err := r.Barrier(5 * time.Second).Error(); err != nil {
+			if err == raft.ErrNotLeader || err == raft.ErrLeadershipLost {
+				return fmt.Errorf("node %s is not leader: %w", dbID, ErrNotLeader)
+			}
+			return err
+		}
+		return nil
+	default:
+		return fmt.Errorf("%w %q", ErrInvalidLevel, level)
+	}
+}
@@ -0,0 +1,51 @@
+package raft
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestParseReadLevel(t *testing.T) {
+	for in, want := range map[string]ReadLevel{"": LevelNone, "none": LevelNone, "weak": LevelWeak, "strong": LevelStrong} {
+		got, err := ParseReadLevel(in)
+		if err != nil || got != want {
+			t.Errorf("ParseReadLevel(%q) = %q, %v; want %q", in, got, err, want)
+		}
+	}
+	if _, err := ParseReadLevel("linearizable"); !errors.Is(err, ErrInvalidLevel) {
+		t.Errorf("got %v, want ErrInvalidLevel", err)
+	}
+}
+
+func TestVerifyRead(t *testing.T) {
+	base := t.TempDir()
+	node1 := newTestNode(t, base, "node1", []string{"db1"}, true)
+	node2 := newTestNode(t, base, "node2", []string{"db1"}, false)
+	waitFor(t, 5*time.Second, node1.AllLeadersOK)
+	for dbID, res := range node1.Join(node2.NodeID(), node2.RaftAddr()) {
+		if !res.Status {
+			t.Fatalf("join node2 to %s failed: %s", dbID, res.Error)
+		}
+	}
+	waitFor(t, 5*time.Second, func() bool { return node2.Rafts["db1"].Leader() != "" })
+
+	for _, level := range []ReadLevel{LevelNone, LevelWeak, LevelStrong} {
+		if err := node1.VerifyRead("db1", level); err != nil {
+			t.Errorf("leader refused %s read: %v", level, err)
+		}
+	}
+
+	if err := node2.VerifyRead("db1", LevelNone); err != nil {
+		t.Errorf("follower refused none read: %v", err)
+	}
+	for _, level := range []ReadLevel{LevelWeak, LevelStrong} {
+		if err := node2.VerifyRead("db1", level); !errors.Is(err, ErrNotLeader) {
+			t.Errorf("follower %s read: got %v, want ErrNotLeader", level, err)
+		}
+	}
+
+	if err := node1.VerifyRead("missing", LevelNone); !errors.Is(err, ErrDBNotFound) {
+		t.Errorf("got %v, want ErrDBNotFound", err)
+	}
+}
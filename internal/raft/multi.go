@@ -1,9 +1,10 @@
 package raft
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"io"
 	"log"
 	"net"
 	"os"
@@ -19,182 +20,488 @@ import (
 
 // DBManager keeps track of all Raft nodes
 type DBManager struct {
-	Rafts map[string]*raft.Raft
-	FSMs  map[string]*sql.SQLFSM
-	mu    sync.RWMutex
+	Rafts  map[string]*raft.Raft
+	FSMs   map[string]*sql.SQLFSM
+	groups map[string]*group
+	mu     sync.RWMutex
+
+	nodeID   string
+	httpAddr string
+	basePath string
+	mux      *Mux
+	cluster  *muxLayer
+	closed   bool
+
+	catalog      *raft.Raft
+	catalogFSM   *CatalogFSM
+	catalogGroup *group
+
+	// httpAddrs caches the HTTP address of peers by Raft address
+	httpAddrs   map[raft.ServerAddress]string
+	httpAddrsMu sync.Mutex
+
+	onReset        []func(dbID string)
+	onSchemaChange []func(dbID string)
+	hooksMu        sync.Mutex
 }
 
+// group holds the per-database resources behind a Raft node
+type group struct {
+	logStore    *raftboltdb.BoltStore
+	stableStore *raftboltdb.BoltStore
+	transport   *MuxTransport
+}
+
+// DefaultApplyTimeout bounds ApplyCommand
+const DefaultApplyTimeout = 5 * time.Second
+
+var (
+	ErrNotLeader    = errors.New("not leader")
+	ErrDBNotFound   = errors.New("database not found")
+	ErrApplyTimeout = errors.New("apply timed out")
+	// ErrLeadershipLost is a command the leader proposed before losing
+	// its leadership: it may or may not have been committed
+	ErrLeadershipLost = errors.New("leadership lost, the command may have been applied")
+)
+
 // Command represents an operation for SQLFSM
 type Command struct {
-	SQL string
+	SQL    string
+	Params []sql.Param `json:",omitempty"`
+	// Statements, when set, run in one transaction instead of SQL
+	Statements      []sql.Statement `json:",omitempty"`
+	ContinueOnError bool            `json:",omitempty"`
+}
+
+// Options configures a DBManager
+type Options struct {
+	// NodeID identifies this node in every Raft group. Defaults to the
+	// advertised Raft address.
+	NodeID   string
+	BasePath string
+	DBIDs    []string
+	// BindAddr is the host:port the multiplexed Raft listener binds to.
+	BindAddr string
+	// AdvertiseAddr is the host:port other nodes dial. Defaults to the
+	// listener address.
+	AdvertiseAddr string
+	// Bootstrap starts every group as a single voter cluster when it has
+	// no existing state.
+	Bootstrap bool
+	// HTTPAddr is the host:port of this node's HTTP API, handed to other
+	// nodes that forward requests here.
+	HTTPAddr string
 }
 
 // NewDBManager initializes multiple Raft nodes (1 per DB) on a single port with multiplexing
 func NewDBManager(basePath string, dbIDs []string, port int) (*DBManager, error) {
-	manager := &DBManager{
-		Rafts: make(map[string]*raft.Raft),
-		FSMs:  make(map[string]*sql.SQLFSM),
+	return NewDBManagerWithOptions(Options{
+		BasePath:  basePath,
+		DBIDs:     dbIDs,
+		BindAddr:  fmt.Sprintf("127.0.0.1:%d", port),
+		Bootstrap: true,
+	})
+}
+
+// NewDBManagerWithOptions initializes one Raft node per DB, all served by a
+// single multiplexed listener
+func NewDBManagerWithOptions(opts Options) (*DBManager, error) {
+	var advertise net.Addr
+	if opts.AdvertiseAddr != "" {
+		addr, err := net.ResolveTCPAddr("tcp", opts.AdvertiseAddr)
+		if err != nil {
+			return nil, err
+		}
+		advertise = addr
 	}
-	//
-	_, trans1 := raft.NewInmemTransport("node1")
-	ln, err := net.Listen("tcp", fmt.Sprintf("127.0.0.1:%d", port))
+	ln, err := net.Listen("tcp", opts.BindAddr)
 	if err != nil {
 		return nil, err
 	}
-	log.Printf("Multiplexed Raft listener on port %d", port)
-
-	for _, dbID := range dbIDs {
-		dbPath := filepath.Join(basePath, dbID)
-		if err := os.MkdirAll(dbPath, 0755); err != nil {
-			return nil, err
-		}
+	mux := NewMux(ln, advertise)
+	log.Printf("Multiplexed Raft listener on %s", ln.Addr())
 
-		// FSM for this DB
-		fsm := sql.NewSQLFSM(filepath.Join(dbPath, "snapshot.sqlite"))
-		manager.FSMs[dbID] = fsm
+	manager := &DBManager{
+		Rafts:     make(map[string]*raft.Raft),
+		FSMs:      make(map[string]*sql.SQLFSM),
+		groups:    make(map[string]*group),
+		nodeID:    opts.NodeID,
+		httpAddr:  opts.HTTPAddr,
+		basePath:  opts.BasePath,
+		mux:       mux,
+		httpAddrs: make(map[raft.ServerAddress]string),
+	}
+	if manager.nodeID == "" {
+		manager.nodeID = mux.Addr().String()
+	}
+	if err := manager.startClusterService(); err != nil {
+		mux.Close()
+		return nil, err
+	}
 
-		// Raft stores
-		logStore, err := raftboltdb.NewBoltStore(filepath.Join(dbPath, "raft-log.bolt"))
-		if err != nil {
-			return nil, err
+	var servers []raft.Server
+	if opts.Bootstrap {
+		// Bootstrap cluster single node
+		servers = manager.localServers()
+	}
+	for _, dbID := range opts.DBIDs {
+		if !validDBID.MatchString(dbID) {
+			manager.Shutdown()
+			return nil, fmt.Errorf("%w %q", ErrInvalidDBName, dbID)
 		}
-		stableStore, err := raftboltdb.NewBoltStore(filepath.Join(dbPath, "raft-stable.bolt"))
-		if err != nil {
+		if err := manager.startGroup(dbID, servers); err != nil {
+			manager.Shutdown()
 			return nil, err
 		}
-		snapshotStore, err := raft.NewFileSnapshotStore(filepath.Join(dbPath, "snapshot"), 1, os.Stdout)
-		if err != nil {
-			return nil, err
+	}
+	if err := manager.startCatalog(servers); err != nil {
+		manager.Shutdown()
+		return nil, err
+	}
+
+	return manager, nil
+}
+
+// startGroup creates the FSM and Raft node of one database. A non-empty
+// servers list bootstraps the group with that configuration.
+func (m *DBManager) startGroup(dbID string, servers []raft.Server) error {
+	if err := os.MkdirAll(filepath.Join(m.basePath, dbID), 0755); err != nil {
+		return err
+	}
+
+	// Raft restores the last snapshot and replays the log after it on
+	// start, so the DB is rebuilt from scratch instead of applying the
+	// same entries twice. Until the replay catches up, level none reads
+	// and the MySQL listener see an empty or partial DB; weak and strong
+	// reads go to the leader and are not affected.
+	if err := removeSQLiteFiles(m.DBPath(dbID)); err != nil {
+		return err
+	}
+
+	// FSM for this DB
+	fsm := sql.NewSQLFSM(m.DBPath(dbID))
+	fsm.OnRestore = func() { m.reset(dbID) }
+	fsm.OnSchemaChange = func() { m.schemaChanged(dbID) }
+	// create the file right away so the DB is listed before its first write
+	if err := fsm.DB.Ping(); err != nil {
+		fsm.Close()
+		return err
+	}
+
+	r, g, err := m.newRaftNode(dbID, fsm, servers)
+	if err != nil {
+		fsm.Close()
+		return err
+	}
+
+	m.mu.Lock()
+	m.Rafts[dbID] = r
+	m.FSMs[dbID] = fsm
+	m.groups[dbID] = g
+	m.mu.Unlock()
+	log.Printf("Raft node %s initialized for DB %s on %s", m.nodeID, dbID, g.transport.LocalAddr())
+	return nil
+}
+
+// removeSQLiteFiles removes a SQLite file with its WAL, shared memory
+// and rollback journal
+func removeSQLiteFiles(path string) error {
+	for _, suffix := range []string{"", "-wal", "-shm", "-journal"} {
+		if err := os.Remove(path + suffix); err != nil && !os.IsNotExist(err) {
+			return err
 		}
+	}
+	return nil
+}
 
-		cfg := raft.DefaultConfig()
-		cfg.LocalID = raft.ServerID(dbID)
-		cfg.HeartbeatTimeout = 100 * time.Millisecond
-		cfg.ElectionTimeout = 100 * time.Millisecond
-		cfg.LeaderLeaseTimeout = 100 * time.Millisecond
-		cfg.SnapshotThreshold = 1024
+// newRaftNode creates the stores and Raft node of the group dbID on the
+// shared mux, bootstrapping it with servers when given
+func (m *DBManager) newRaftNode(dbID string, fsm raft.FSM, servers []raft.Server) (*raft.Raft, *group, error) {
+	dbPath := filepath.Join(m.basePath, dbID)
+	if err := os.MkdirAll(dbPath, 0755); err != nil {
+		return nil, nil, err
+	}
 
-		trans := NewMuxTransport(ln, dbID)
-		r, err := raft.NewRaft(cfg, fsm, logStore, stableStore, snapshotStore, trans1)
-		if err != nil {
-			return nil, err
+	// Raft stores
+	logStore, err := raftboltdb.NewBoltStore(filepath.Join(dbPath, "raft-log.bolt"))
+	if err != nil {
+		return nil, nil, err
+	}
+	stableStore, err := raftboltdb.NewBoltStore(filepath.Join(dbPath, "raft-stable.bolt"))
+	if err != nil {
+		logStore.Close()
+		return nil, nil, err
+	}
+	g := &group{logStore: logStore, stableStore: stableStore}
+	snapshotStore, err := raft.NewFileSnapshotStore(filepath.Join(dbPath, "snapshot"), 1, os.Stdout)
+	if err != nil {
+		g.close()
+		return nil, nil, err
+	}
+
+	cfg := raft.DefaultConfig()
+	cfg.LocalID = raft.ServerID(m.nodeID)
+	cfg.HeartbeatTimeout = 100 * time.Millisecond
+	cfg.ElectionTimeout = 100 * time.Millisecond
+	cfg.LeaderLeaseTimeout = 100 * time.Millisecond
+	cfg.SnapshotThreshold = 1024
+
+	trans, err := NewMuxTransport(m.mux, dbID)
+	if err != nil {
+		g.close()
+		return nil, nil, err
+	}
+	g.transport = trans
+	r, err := raft.NewRaft(cfg, fsm, logStore, stableStore, snapshotStore, trans)
+	if err != nil {
+		g.close()
+		return nil, nil, err
+	}
+
+	if len(servers) > 0 {
+		err := r.BootstrapCluster(raft.Configuration{Servers: servers}).Error()
+		if err != nil && err != raft.ErrCantBootstrap {
+			r.Shutdown()
+			g.close()
+			return nil, nil, err
 		}
+	}
+	return r, g, nil
+}
 
-		// Bootstrap cluster single node
-		r.BootstrapCluster(raft.Configuration{
-			Servers: []raft.Server{
-				{
-					ID:      raft.ServerID(dbID),
-					Address: trans.LocalAddr(),
-				},
-			},
-		})
+// localServers is the configuration of a group bootstrapped by this
+// node alone
+func (m *DBManager) localServers() []raft.Server {
+	return []raft.Server{
+		{
+			ID:      raft.ServerID(m.nodeID),
+			Address: raft.ServerAddress(m.RaftAddr()),
+		},
+	}
+}
 
-		manager.Rafts[dbID] = r
-		log.Printf("Raft node %s initialized on muxed port %d", dbID, port)
+func (g *group) close() {
+	if g.transport != nil {
+		g.transport.Close()
 	}
+	g.logStore.Close()
+	g.stableStore.Close()
+}
 
-	return manager, nil
+// ApplyCommand replicates cmd through the Raft group of a DB and returns
+// what the leader's FSM reported: rows affected, last insert id, errors
+// and timings per statement. SQL errors are part of the result, the
+// returned error only covers replication.
+func (m *DBManager) ApplyCommand(dbID string, cmd Command) (*sql.ApplyResult, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), DefaultApplyTimeout)
+	defer cancel()
+	return m.ApplyCommandContext(ctx, dbID, cmd)
 }
 
-func (m *DBManager) ApplyCommand(dbID string, cmd Command) error {
+// ApplyCommandContext is ApplyCommand bounded by ctx. Once the command is
+// in the log it runs to completion on every replica, so ctx only bounds
+// the wait: ErrApplyTimeout, ErrLeadershipLost or a cancellation leaves
+// the outcome unknown. ErrNotLeader means the command was not proposed.
+func (m *DBManager) ApplyCommandContext(ctx context.Context, dbID string, cmd Command) (*sql.ApplyResult, error) {
 	m.mu.RLock()
 	r, ok := m.Rafts[dbID]
 	m.mu.RUnlock()
 	if !ok {
-		return fmt.Errorf("DB %s not found", dbID)
+		return nil, fmt.Errorf("DB %s not found: %w", dbID, ErrDBNotFound)
 	}
 
+	m.mu.RLock()
 	_, ok = m.FSMs[dbID]
+	m.mu.RUnlock()
 	if !ok {
-		return fmt.Errorf("FSM for DB %s not found", dbID)
+		return nil, fmt.Errorf("FSM for DB %s not found", dbID)
 	}
 
+	if r.State() != raft.Leader {
+		return nil, fmt.Errorf("node %s is not leader: %w", dbID, ErrNotLeader)
+	}
+
+	// replicas must not evaluate time or randomness on their own
+	if err := rewriteCommand(&cmd, time.Now()); err != nil {
+		return nil, err
+	}
 	data, err := json.Marshal(cmd)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	if r.State() != raft.Leader {
-		return fmt.Errorf("node %s is not leader", dbID)
+	timeout := DefaultApplyTimeout
+	if deadline, ok := ctx.Deadline(); ok {
+		timeout = time.Until(deadline)
+	}
+	if timeout <= 0 || errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return nil, fmt.Errorf("DB %s: %w", dbID, ErrApplyTimeout)
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
 	}
+	future := r.Apply(data, timeout)
+	done := make(chan error, 1)
+	go func() { done <- future.Error() }()
 
-	future := r.Apply(data, 5*time.Second)
-	return future.Error()
+	select {
+	case err := <-done:
+		switch {
+		case err == nil:
+		case err == raft.ErrNotLeader:
+			return nil, fmt.Errorf("node %s is not leader: %w", dbID, ErrNotLeader)
+		case err == raft.ErrLeadershipLost:
+			return nil, fmt.Errorf("DB %s: %w", dbID, ErrLeadershipLost)
+		case err == raft.ErrEnqueueTimeout:
+			return nil, fmt.Errorf("DB %s: %w", dbID, ErrApplyTimeout)
+		default:
+			return nil, err
+		}
+	case <-ctx.Done():
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return nil, fmt.Errorf("DB %s: %w, the command may still be applied", dbID, ErrApplyTimeout)
+		}
+		return nil, ctx.Err()
+	}
+	result, _ := future.Response().(*sql.ApplyResult)
+	return result, nil
 }
 
-func (m *DBManager) AllLeadersOK() bool {
-	m.mu.RLock()
-	defer m.mu.RUnlock()
-	for _, r := range m.Rafts {
-		if r.State() != raft.Leader {
-			return false
+// rewriteCommand makes every statement of cmd deterministic
+func rewriteCommand(cmd *Command, now time.Time) error {
+	var err error
+	if cmd.SQL != "" {
+		if cmd.SQL, err = sql.Rewrite(cmd.SQL, now); err != nil {
+			return err
 		}
 	}
-	return true
+	if len(cmd.Statements) == 0 {
+		return nil
+	}
+	stmts := make([]sql.Statement, len(cmd.Statements))
+	for i, stmt := range cmd.Statements {
+		if stmt.SQL, err = sql.Rewrite(stmt.SQL, now); err != nil {
+			return fmt.Errorf("statement %d: %w", i, err)
+		}
+		stmts[i] = stmt
+	}
+	cmd.Statements = stmts
+	return nil
 }
 
-type MuxTransport struct {
-	listener net.Listener
-	dbID     string
+// OnReset registers fn to be called whenever the SQLite file of a DB is
+// removed or replaced, after a drop or a snapshot restore. Anything that
+// keeps the file open must reopen it.
+func (m *DBManager) OnReset(fn func(dbID string)) {
+	m.hooksMu.Lock()
+	m.onReset = append(m.onReset, fn)
+	m.hooksMu.Unlock()
 }
 
-func NewMuxTransport(ln net.Listener, dbID string) *MuxTransport {
-	t := &MuxTransport{
-		listener: ln,
-		dbID:     dbID,
+func (m *DBManager) reset(dbID string) {
+	m.hooksMu.Lock()
+	fns := m.onReset
+	m.hooksMu.Unlock()
+	for _, fn := range fns {
+		fn(dbID)
 	}
-	go t.acceptLoop()
-	return t
 }
 
-func (t *MuxTransport) acceptLoop() {
-	for {
-		conn, err := t.listener.Accept()
-		if err != nil {
-			log.Printf("MuxTransport accept error: %v", err)
-			return
-		}
-		go t.handleConn(conn)
+// OnSchemaChange registers fn to be called on every node after a command
+// changed the schema of a DB, so cached prepared statements can be
+// dropped
+func (m *DBManager) OnSchemaChange(fn func(dbID string)) {
+	m.hooksMu.Lock()
+	m.onSchemaChange = append(m.onSchemaChange, fn)
+	m.hooksMu.Unlock()
+}
+
+func (m *DBManager) schemaChanged(dbID string) {
+	m.hooksMu.Lock()
+	fns := m.onSchemaChange
+	m.hooksMu.Unlock()
+	for _, fn := range fns {
+		fn(dbID)
 	}
 }
 
-func (t *MuxTransport) handleConn(conn net.Conn) {
-	defer conn.Close()
-	idBuf := make([]byte, len(t.dbID))
-	_, err := io.ReadFull(conn, idBuf)
+// IsLeader reports whether this node leads the Raft group of a DB or
+// the catalog
+func (m *DBManager) IsLeader(dbID string) (bool, error) {
+	r, err := m.Raft(dbID)
 	if err != nil {
-		log.Printf("MuxTransport read DBID error: %v", err)
-		return
-	}
-	recvDBID := string(idBuf)
-	if recvDBID != t.dbID {
-		return
+		return false, err
 	}
-	// TODO: Now pass this connection to Raft and apply it
+	return r.State() == raft.Leader, nil
 }
 
-// raft.Transport interface
-func (t *MuxTransport) LocalAddr() raft.ServerAddress {
-	return raft.ServerAddress(t.listener.Addr().String())
-}
-func (t *MuxTransport) AppendEntriesPipeline(id raft.ServerID, target raft.ServerAddress) (raft.AppendPipeline, error) {
-	return nil, nil
+func (m *DBManager) AllLeadersOK() bool {
+	if m.catalog.State() != raft.Leader {
+		return false
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	for _, r := range m.Rafts {
+		if r.State() != raft.Leader {
+			return false
+		}
+	}
+	return true
 }
-func (t *MuxTransport) AppendEntries(id raft.ServerID, target raft.ServerAddress, args *raft.AppendEntriesRequest, resp *raft.AppendEntriesResponse) error {
-	return nil
+
+// DBPath returns the SQLite file holding the data of a DB
+func (m *DBManager) DBPath(dbID string) string {
+	return filepath.Join(m.basePath, dbID+".db")
 }
-func (t *MuxTransport) RequestVote(id raft.ServerID, target raft.ServerAddress, args *raft.RequestVoteRequest, resp *raft.RequestVoteResponse) error {
-	return nil
+
+// NodeID returns the server id this node uses in every Raft group
+func (m *DBManager) NodeID() string {
+	return m.nodeID
 }
-func (t *MuxTransport) InstallSnapshot(id raft.ServerID, target raft.ServerAddress, args *raft.InstallSnapshotRequest, resp *raft.InstallSnapshotResponse) error {
-	return nil
+
+// HTTPAddr returns the address of this node's HTTP API
+func (m *DBManager) HTTPAddr() string {
+	return m.httpAddr
 }
-func (t *MuxTransport) EncodePeer(id raft.ServerID, addr raft.ServerAddress, w io.Writer) error {
-	return nil
+
+// RaftAddr returns the address other nodes use to reach this node's groups
+func (m *DBManager) RaftAddr() string {
+	return m.mux.Addr().String()
 }
-func (t *MuxTransport) DecodePeer(r io.Reader) (raft.ServerID, raft.ServerAddress, error) {
-	return "", "", nil
+
+// Shutdown stops every Raft node, closes their stores and FSMs and
+// releases the listener
+func (m *DBManager) Shutdown() error {
+	var firstErr error
+	// the catalog starts groups while applying, so stop it before taking
+	// the lock those applies need
+	if m.catalog != nil {
+		firstErr = m.catalog.Shutdown().Error()
+		m.catalogGroup.close()
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.closed = true
+	for dbID, r := range m.Rafts {
+		if err := r.Shutdown().Error(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+		delete(m.Rafts, dbID)
+	}
+	for dbID, g := range m.groups {
+		g.close()
+		delete(m.groups, dbID)
+	}
+	for dbID, fsm := range m.FSMs {
+		if err := fsm.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+		delete(m.FSMs, dbID)
+	}
+	m.cluster.Close()
+	if err := m.mux.Close(); err != nil && firstErr == nil {
+		firstErr = err
+	}
+	return firstErr
 }
-func (t *MuxTransport) Consumer() <-chan raft.RPC { return nil }
-func (t *MuxTransport) Cancel()                   {}
@@ -22,7 +22,7 @@ func BenchmarkRaftApplyInsert(b *testing.B) {
 		SQL: "CREATE TABLE IF NOT EXISTS test_table (id INTEGER PRIMARY KEY, val TEXT)",
 	}
 	for _, db := range dbIDs {
-		if err := manager.ApplyCommand(db, createCmd); err != nil {
+		if _, err := manager.ApplyCommand(db, createCmd); err != nil {
 			b.Fatalf("failed to create table for %s: %v", db, err)
 		}
 	}
@@ -38,7 +38,7 @@ func BenchmarkRaftApplyInsert(b *testing.B) {
 		}
 
 		for _, db := range dbIDs {
-			if err := manager.ApplyCommand(db, cmd); err != nil {
+			if _, err := manager.ApplyCommand(db, cmd); err != nil {
 				b.Fatalf("apply failed for %s: %v", db, err)
 			}
 		}
@@ -1,6 +1,8 @@
 package raft
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"testing"
 	"time"
@@ -30,7 +32,7 @@ func TestDBManager(t *testing.T) {
 		createCmd := Command{
 			SQL: "CREATE TABLE IF NOT EXISTS test_table (id INTEGER PRIMARY KEY)",
 		}
-		if err := manager.ApplyCommand(db, createCmd); err != nil {
+		if _, err := manager.ApplyCommand(db, createCmd); err != nil {
 			t.Fatalf("failed to create table for %s: %v", db, err)
 		}
 	}
@@ -39,7 +41,7 @@ func TestDBManager(t *testing.T) {
 		cmd := Command{
 			SQL: fmt.Sprintf("INSERT INTO test_table (id) VALUES (%d)", i+1),
 		}
-		if err := manager.ApplyCommand(db, cmd); err != nil {
+		if _, err := manager.ApplyCommand(db, cmd); err != nil {
 			t.Fatalf("failed to apply command for %s: %v", db, err)
 		}
 	}
@@ -231,3 +233,99 @@ func TestMultipleDBManagersConnectivity(t *testing.T) {
 		}
 	}
 }
+
+func TestApplyCommandResult(t *testing.T) {
+	node := newTestNode(t, t.TempDir(), "node1", []string{"db1"}, true)
+	waitFor(t, 5*time.Second, node.AllLeadersOK)
+
+	if _, err := node.ApplyCommand("db1", Command{SQL: "CREATE TABLE t (id INTEGER PRIMARY KEY)"}); err != nil {
+		t.Fatalf("apply failed: %v", err)
+	}
+	result, err := node.ApplyCommand("db1", Command{SQL: "INSERT INTO t (id) VALUES (42)"})
+	if err != nil {
+		t.Fatalf("apply failed: %v", err)
+	}
+	if !result.Committed || result.Results[0].LastInsertID != 42 || result.Results[0].RowsAffected != 1 {
+		t.Fatalf("unexpected result %+v", result)
+	}
+
+	result, err = node.ApplyCommand("db1", Command{SQL: "INSERT INTO missing VALUES (1)"})
+	if err != nil {
+		t.Fatalf("apply failed: %v", err)
+	}
+	if result.Committed || result.Err() == nil {
+		t.Fatalf("expected SQL error in result, got %+v", result)
+	}
+}
+
+func TestRestartDoesNotReapplyLog(t *testing.T) {
+	base := t.TempDir()
+	node := newTestNode(t, base, "node1", []string{"db1"}, true)
+	waitFor(t, 5*time.Second, node.AllLeadersOK)
+	for _, q := range []string{"CREATE TABLE t (id INTEGER)", "INSERT INTO t VALUES (1)"} {
+		if _, err := node.ApplyCommand("db1", Command{SQL: q}); err != nil {
+			t.Fatalf("apply %q failed: %v", q, err)
+		}
+	}
+	node.Shutdown()
+
+	// the applied index moves before the FSM runs an entry, so wait for
+	// the replayed row itself
+	restarted := newTestNode(t, base, "node1", []string{"db1"}, true)
+	count := func() (int, error) {
+		restarted.mu.RLock()
+		fsm := restarted.FSMs["db1"]
+		restarted.mu.RUnlock()
+		var n int
+		err := fsm.DB.QueryRow("SELECT COUNT(*) FROM t").Scan(&n)
+		return n, err
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		n, err := count()
+		if err == nil && n > 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected the row to be replayed: %d rows, %v", n, err)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	// the leader applies in log order, so once this write returns the
+	// whole log has been replayed
+	waitFor(t, 5*time.Second, restarted.AllLeadersOK)
+	if _, err := restarted.ApplyCommand("db1", Command{SQL: "INSERT INTO t VALUES (2)"}); err != nil {
+		t.Fatal(err)
+	}
+	n, err := count()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 {
+		t.Fatalf("expected 2 rows after restart, got %d", n)
+	}
+}
+
+func TestApplyCommandContext(t *testing.T) {
+	node := newTestNode(t, t.TempDir(), "node1", []string{"db1"}, true)
+	waitFor(t, 5*time.Second, node.AllLeadersOK)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if _, err := node.ApplyCommandContext(ctx, "db1", Command{SQL: "CREATE TABLE t (id INTEGER)"}); err != nil {
+		t.Fatalf("apply failed: %v", err)
+	}
+
+	expired, cancelExpired := context.WithTimeout(context.Background(), -time.Second)
+	defer cancelExpired()
+	if _, err := node.ApplyCommandContext(expired, "db1", Command{SQL: "INSERT INTO t VALUES (1)"}); !errors.Is(err, ErrApplyTimeout) {
+		t.Fatalf("got %v, want ErrApplyTimeout", err)
+	}
+
+	cancelled, cancelNow := context.WithCancel(context.Background())
+	cancelNow()
+	if _, err := node.ApplyCommandContext(cancelled, "db1", Command{SQL: "INSERT INTO t VALUES (2)"}); err == nil || errors.Is(err, ErrApplyTimeout) {
+		t.Fatalf("got %v, want a cancellation error", err)
+	}
+}
@@ -0,0 +1,206 @@
+package raft
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/raft"
+)
+
+const (
+	// muxHeaderTimeout bounds how long an incoming connection may take to
+	// send the database header before it is dropped.
+	muxHeaderTimeout = 5 * time.Second
+	// maxDBIDLen is the largest database id that fits in the frame header.
+	maxDBIDLen = 1<<16 - 1
+)
+
+var ErrMuxClosed = errors.New("mux transport closed")
+
+// Mux serves every Raft group of a node on a single listener. Each
+// connection starts with a header naming the database whose group it is
+// for: a big-endian uint16 length followed by the database id.
+type Mux struct {
+	listener  net.Listener
+	advertise net.Addr
+	mu        sync.RWMutex
+	layers    map[string]*muxLayer
+}
+
+// NewMux starts accepting connections on ln. advertise is the address
+// peers use to reach this node; when nil the listener address is used.
+func NewMux(ln net.Listener, advertise net.Addr) *Mux {
+	if advertise == nil {
+		advertise = ln.Addr()
+	}
+	m := &Mux{
+		listener:  ln,
+		advertise: advertise,
+		layers:    make(map[string]*muxLayer),
+	}
+	go m.acceptLoop()
+	return m
+}
+
+func (m *Mux) acceptLoop() {
+	for {
+		conn, err := m.listener.Accept()
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				log.Printf("MuxTransport accept error: %v", err)
+			}
+			return
+		}
+		go m.handleConn(conn)
+	}
+}
+
+func (m *Mux) handleConn(conn net.Conn) {
+	conn.SetReadDeadline(time.Now().Add(muxHeaderTimeout))
+	dbID, err := readHeader(conn)
+	if err != nil {
+		log.Printf("MuxTransport read DBID error: %v", err)
+		conn.Close()
+		return
+	}
+	conn.SetReadDeadline(time.Time{})
+
+	m.mu.RLock()
+	layer, ok := m.layers[dbID]
+	m.mu.RUnlock()
+	if !ok {
+		conn.Close()
+		return
+	}
+	select {
+	case layer.connCh <- conn:
+	case <-layer.closeCh:
+		conn.Close()
+	}
+}
+
+// Addr returns the address peers use to reach this node.
+func (m *Mux) Addr() net.Addr {
+	return m.advertise
+}
+
+// Close stops the listener. Transports already handed out keep working
+// for outgoing RPCs until they are closed themselves.
+func (m *Mux) Close() error {
+	return m.listener.Close()
+}
+
+func (m *Mux) register(dbID string) (*muxLayer, error) {
+	if len(dbID) == 0 || len(dbID) > maxDBIDLen {
+		return nil, fmt.Errorf("invalid DB id %q", dbID)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.layers[dbID]; ok {
+		return nil, fmt.Errorf("transport for DB %s already registered", dbID)
+	}
+	layer := &muxLayer{
+		mux:     m,
+		dbID:    dbID,
+		connCh:  make(chan net.Conn),
+		closeCh: make(chan struct{}),
+	}
+	m.layers[dbID] = layer
+	return layer, nil
+}
+
+func (m *Mux) unregister(dbID string) {
+	m.mu.Lock()
+	delete(m.layers, dbID)
+	m.mu.Unlock()
+}
+
+func writeHeader(w io.Writer, dbID string) error {
+	buf := make([]byte, 2+len(dbID))
+	binary.BigEndian.PutUint16(buf, uint16(len(dbID)))
+	copy(buf[2:], dbID)
+	_, err := w.Write(buf)
+	return err
+}
+
+func readHeader(r io.Reader) (string, error) {
+	var size [2]byte
+	if _, err := io.ReadFull(r, size[:]); err != nil {
+		return "", err
+	}
+	idBuf := make([]byte, binary.BigEndian.Uint16(size[:]))
+	if _, err := io.ReadFull(r, idBuf); err != nil {
+		return "", err
+	}
+	return string(idBuf), nil
+}
+
+// muxLayer is the raft.StreamLayer of one database on a Mux.
+type muxLayer struct {
+	mux       *Mux
+	dbID      string
+	connCh    chan net.Conn
+	closeCh   chan struct{}
+	closeOnce sync.Once
+}
+
+func (l *muxLayer) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.connCh:
+		return conn, nil
+	case <-l.closeCh:
+		return nil, ErrMuxClosed
+	}
+}
+
+func (l *muxLayer) Close() error {
+	l.closeOnce.Do(func() {
+		close(l.closeCh)
+		l.mux.unregister(l.dbID)
+	})
+	return nil
+}
+
+func (l *muxLayer) Addr() net.Addr {
+	return l.mux.Addr()
+}
+
+func (l *muxLayer) Dial(address raft.ServerAddress, timeout time.Duration) (net.Conn, error) {
+	conn, err := net.DialTimeout("tcp", string(address), timeout)
+	if err != nil {
+		return nil, err
+	}
+	conn.SetWriteDeadline(time.Now().Add(timeout))
+	if err := writeHeader(conn, l.dbID); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	conn.SetWriteDeadline(time.Time{})
+	return conn, nil
+}
+
+// MuxTransport is the raft.Transport of a single database group. RPC
+// encoding, pipelining and snapshot streaming come from the embedded
+// raft.NetworkTransport; the Mux only routes connections to it.
+type MuxTransport struct {
+	*raft.NetworkTransport
+	dbID string
+}
+
+func NewMuxTransport(mux *Mux, dbID string) (*MuxTransport, error) {
+	layer, err := mux.register(dbID)
+	if err != nil {
+		return nil, err
+	}
+	return &MuxTransport{
+		NetworkTransport: raft.NewNetworkTransport(layer, 3, 10*time.Second, os.Stderr),
+		dbID:             dbID,
+	}, nil
+}
@@ -0,0 +1,94 @@
+package raft
+
+import (
+	"bytes"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/raft"
+)
+
+func TestMuxHeaderRoundTrip(t *testing.T) {
+	var buf bytes.Buffer
+	if err := writeHeader(&buf, "db1"); err != nil {
+		t.Fatal(err)
+	}
+	dbID, err := readHeader(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dbID != "db1" {
+		t.Fatalf("expected db1, got %s", dbID)
+	}
+}
+
+func waitFor(t *testing.T, timeout time.Duration, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		if cond() {
+			return
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	t.Fatal("condition not met before timeout")
+}
+
+func TestMuxTransportReplicatesAcrossNodes(t *testing.T) {
+	dbIDs := []string{"db1", "db2"}
+	base := t.TempDir()
+
+	leader, err := NewDBManagerWithOptions(Options{
+		NodeID:    "node1",
+		BasePath:  filepath.Join(base, "node1"),
+		DBIDs:     dbIDs,
+		BindAddr:  "127.0.0.1:0",
+		Bootstrap: true,
+	})
+	if err != nil {
+		t.Fatalf("failed to create leader: %v", err)
+	}
+	defer leader.Shutdown()
+
+	follower, err := NewDBManagerWithOptions(Options{
+		NodeID:   "node2",
+		BasePath: filepath.Join(base, "node2"),
+		DBIDs:    dbIDs,
+		BindAddr: "127.0.0.1:0",
+	})
+	if err != nil {
+		t.Fatalf("failed to create follower: %v", err)
+	}
+	defer follower.Shutdown()
+
+	waitFor(t, 5*time.Second, leader.AllLeadersOK)
+
+	for _, db := range dbIDs {
+		r := leader.Rafts[db]
+		if err := r.AddVoter(raft.ServerID(follower.NodeID()), raft.ServerAddress(follower.RaftAddr()), 0, 5*time.Second).Error(); err != nil {
+			t.Fatalf("failed to add voter for %s: %v", db, err)
+		}
+		cmd := Command{SQL: "CREATE TABLE IF NOT EXISTS test_table (id INTEGER PRIMARY KEY)"}
+		if _, err := leader.ApplyCommand(db, cmd); err != nil {
+			t.Fatalf("failed to apply command for %s: %v", db, err)
+		}
+	}
+
+	for _, db := range dbIDs {
+		fsm := follower.FSMs[db]
+		waitFor(t, 5*time.Second, func() bool {
+			var name string
+			err := fsm.DB.QueryRow("SELECT name FROM sqlite_master WHERE name = 'test_table'").Scan(&name)
+			return err == nil
+		})
+	}
+
+	// leadership transfer exercises the TimeoutNow RPC
+	if err := leader.Rafts["db1"].LeadershipTransfer().Error(); err != nil {
+		t.Fatalf("leadership transfer failed: %v", err)
+	}
+	waitFor(t, 5*time.Second, func() bool {
+		return follower.Rafts["db1"].State() == raft.Leader
+	})
+}
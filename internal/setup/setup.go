@@ -1,39 +1,118 @@
 package setup
 
-// TODO: write this file later
-import "github.com/hashicorp/raft"
-
-// import (
-// 	"fmt"
-// 	r "rflite/internal/raft"
-// 	"rflite/internal/sql"
-// 	"time"
-
-// 	"github.com/hashicorp/raft"
-// )
-
-func SetupLeader() (*raft.Raft, error) {
-	// 	fsm := sql.NewSQLFSM("testdata/db_single.db")
-	// 	_, trans := raft.NewInmemTransport("master") // master
-	// 	// TODO: use proper bind address
-	// 	r, err := r.NewTestRaftNode("master", "127.0.0.1:13000", fsm, trans, true) //
-	// 	if err != nil {
-	// 		return nil, err
-	// 	}
-	// 	if err := waitForLeader(r, 2*time.Second); err != nil {
-	// 		return nil, err
-	// 	}
-	// 	return r, nil
-	// }
-
-	//	func waitForLeader(r *raft.Raft, timeout time.Duration) error {
-	//		deadline := time.Now().Add(timeout)
-	//		for time.Now().Before(deadline) {
-	//			if r.State() == raft.Leader {
-	//				return nil
-	//			}
-	//			time.Sleep(10 * time.Millisecond)
-	//		}
-	//		return fmt.Errorf("leader not elected")
-	return nil, nil
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"rflite/config"
+	r "rflite/internal/raft"
+)
+
+const joinAttempts = 5
+
+// SetupNode starts the Raft groups described by cfg. A master bootstraps
+// every group on first start, any other node asks cfg.Join to add it to
+// the cluster.
+func SetupNode(cfg *config.Config) (*r.DBManager, error) {
+	master := cfg.Type == "master"
+	httpAddr := cfg.HTTPAddr
+	if httpAddr == "" {
+		httpAddr = fmt.Sprintf("127.0.0.1:%d", cfg.Port)
+	}
+	manager, err := r.NewDBManagerWithOptions(r.Options{
+		NodeID:        cfg.Name,
+		BasePath:      cfg.DataDir,
+		DBIDs:         cfg.Databases,
+		BindAddr:      cfg.RaftAddr,
+		AdvertiseAddr: cfg.AdvertiseAddr,
+		Bootstrap:     master,
+		HTTPAddr:      httpAddr,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if master {
+		if err := waitForLeader(manager, 5*time.Second); err != nil {
+			manager.Shutdown()
+			return nil, err
+		}
+		return manager, nil
+	}
+	if cfg.Join == "" {
+		return manager, nil
+	}
+	if err := join(cfg.Join, manager); err != nil {
+		manager.Shutdown()
+		return nil, err
+	}
+	return manager, nil
+}
+
+func waitForLeader(m *r.DBManager, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		if m.AllLeadersOK() {
+			return nil
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return fmt.Errorf("leader not elected")
+}
+
+// join asks the member at addr to add this node to every database group
+func join(addr string, m *r.DBManager) error {
+	body, err := json.Marshal(r.JoinRequest{
+		NodeID:   m.NodeID(),
+		RaftAddr: m.RaftAddr(),
+	})
+	if err != nil {
+		return err
+	}
+
+	for attempt := 1; ; attempt++ {
+		err = joinOnce(addr, body)
+		if err == nil {
+			log.Printf("joined cluster through %s", addr)
+			return nil
+		}
+		if attempt == joinAttempts {
+			return fmt.Errorf("join %s: %w", addr, err)
+		}
+		log.Printf("join attempt %d through %s failed: %v", attempt, addr, err)
+		time.Sleep(time.Second)
+	}
+}
+
+func joinOnce(addr string, body []byte) error {
+	resp, err := http.Post("http://"+addr+"/connect", "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	var out struct {
+		Status bool                    `json:"status"`
+		Error  string                  `json:"error"`
+		Result map[string]r.JoinResult `json:"result"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return err
+	}
+	if out.Status {
+		return nil
+	}
+	if out.Error != "" {
+		return fmt.Errorf("%s", out.Error)
+	}
+	for dbID, res := range out.Result {
+		if !res.Status {
+			return fmt.Errorf("DB %s: %s", dbID, res.Error)
+		}
+	}
+	return fmt.Errorf("join rejected")
 }
@@ -0,0 +1,146 @@
+package sql
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// Param types, matching the SQLite storage classes
+const (
+	ParamInt   = "int"
+	ParamFloat = "float"
+	ParamText  = "text"
+	ParamBlob  = "blob"
+	ParamNull  = "null"
+)
+
+// Param is a value bound to a statement. It is positional unless Name is
+// set. Params are replicated as {"type": ..., "value": ...} so every
+// replica binds exactly the same typed value; blobs are base64 encoded.
+type Param struct {
+	Name  string
+	Type  string
+	Value interface{}
+}
+
+type paramJSON struct {
+	Name  string          `json:"name,omitempty"`
+	Type  string          `json:"type"`
+	Value json.RawMessage `json:"value,omitempty"`
+}
+
+func (p Param) MarshalJSON() ([]byte, error) {
+	out := paramJSON{Name: p.Name, Type: p.Type}
+	if p.Type != ParamNull {
+		value := p.Value
+		if b, ok := value.([]byte); ok {
+			value = base64.StdEncoding.EncodeToString(b)
+		}
+		raw, err := json.Marshal(value)
+		if err != nil {
+			return nil, err
+		}
+		out.Value = raw
+	}
+	return json.Marshal(out)
+}
+
+// UnmarshalJSON accepts the typed object form and, for convenience, bare
+// JSON scalars: integers become int, other numbers float, strings text
+// and null null. Blobs always need the typed form.
+func (p *Param) UnmarshalJSON(data []byte) error {
+	data = bytes.TrimSpace(data)
+	if len(data) > 0 && data[0] == '{' {
+		var in paramJSON
+		if err := json.Unmarshal(data, &in); err != nil {
+			return err
+		}
+		v, err := decodeValue(in.Type, in.Value)
+		if err != nil {
+			return fmt.Errorf("param %s: %w", in.Name, err)
+		}
+		*p = Param{Name: in.Name, Type: in.Type, Value: v}
+		return nil
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		return err
+	}
+	switch v := v.(type) {
+	case nil:
+		*p = Param{Type: ParamNull}
+	case string:
+		*p = Param{Type: ParamText, Value: v}
+	case json.Number:
+		if i, err := v.Int64(); err == nil && !strings.ContainsAny(v.String(), ".eE") {
+			*p = Param{Type: ParamInt, Value: i}
+			return nil
+		}
+		f, err := v.Float64()
+		if err != nil {
+			return err
+		}
+		*p = Param{Type: ParamFloat, Value: f}
+	default:
+		return fmt.Errorf("unsupported param %s", data)
+	}
+	return nil
+}
+
+func decodeValue(typ string, raw json.RawMessage) (interface{}, error) {
+	if typ == ParamNull {
+		return nil, nil
+	}
+	if len(raw) == 0 {
+		return nil, fmt.Errorf("missing value for type %q", typ)
+	}
+	switch typ {
+	case ParamInt:
+		var i int64
+		err := json.Unmarshal(raw, &i)
+		return i, err
+	case ParamFloat:
+		var f float64
+		err := json.Unmarshal(raw, &f)
+		return f, err
+	case ParamText:
+		var s string
+		err := json.Unmarshal(raw, &s)
+		return s, err
+	case ParamBlob:
+		var s string
+		if err := json.Unmarshal(raw, &s); err != nil {
+			return nil, err
+		}
+		return base64.StdEncoding.DecodeString(s)
+	default:
+		return nil, fmt.Errorf("unknown param type %q", typ)
+	}
+}
+
+// Arg returns the value to pass to database/sql
+func (p Param) Arg() interface{} {
+	if p.Name != "" {
+		return sql.Named(strings.TrimLeft(p.Name, ":@$"), p.Value)
+	}
+	return p.Value
+}
+
+// Args converts params into database/sql arguments
+func Args(params []Param) []interface{} {
+	if len(params) == 0 {
+		return nil
+	}
+	args := make([]interface{}, len(params))
+	for i, p := range params {
+		args[i] = p.Arg()
+	}
+	return args
+}
@@ -0,0 +1,239 @@
+package sql
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"rflite/pkg"
+)
+
+// maxRandomBlob bounds randomblob(N) literals written into the log
+const maxRandomBlob = 1 << 20
+
+var ErrNonDeterministic = errors.New("non-deterministic SQL")
+
+// timeFuncs take a time value that may be 'now'. The ones marked true
+// default to 'now' when called without arguments.
+var timeFuncs = map[string]bool{
+	"date":      true,
+	"time":      true,
+	"datetime":  true,
+	"julianday": true,
+	"unixepoch": true,
+	"strftime":  false,
+	"timediff":  false,
+}
+
+// Rewrite replaces the non-deterministic parts of sqlStr with literals,
+// so every replica applying the result writes the same data:
+// CURRENT_TIMESTAMP, CURRENT_DATE, CURRENT_TIME and 'now' in the date and
+// time functions use now, random() and randomblob(N) get fresh random
+// literals. Statements where the result cannot match the original are
+// rejected with ErrNonDeterministic: random values evaluated once per row
+// (UPDATE, DELETE, INSERT ... SELECT), schema that would evaluate them
+// later (CREATE, ALTER, including the body of a CREATE TRIGGER), the
+// 'localtime' and 'utc' modifiers and bound parameters in the date and
+// time functions.
+func Rewrite(sqlStr string, now time.Time) (string, error) {
+	// split the way SQLite does, a trigger body is part of its CREATE
+	stmts, err := pkg.SplitStatements(sqlStr)
+	if err != nil {
+		return "", err
+	}
+	tokens, err := pkg.Tokenize(sqlStr)
+	if err != nil {
+		return "", err
+	}
+	now = now.UTC()
+
+	var out strings.Builder
+	changed := false
+	last, i := 0, 0
+	for _, stmt := range stmts {
+		end := stmt.Pos + len(stmt.SQL)
+		for i < len(tokens) && tokens[i].Pos < stmt.Pos {
+			i++
+		}
+		start := i
+		for i < len(tokens) && tokens[i].Pos < end {
+			i++
+		}
+		rewritten, stmtChanged, err := rewriteStatement(tokens[start:i], now)
+		if err != nil {
+			return "", err
+		}
+		changed = changed || stmtChanged
+		out.WriteString(sqlStr[last:stmt.Pos])
+		out.WriteString(rewritten)
+		last = end
+	}
+	if !changed {
+		return sqlStr, nil
+	}
+	out.WriteString(sqlStr[last:])
+	return out.String(), nil
+}
+
+func rewriteStatement(tokens []pkg.Token, now time.Time) (string, bool, error) {
+	// positions of the tokens that are neither whitespace nor comments
+	var sig []int
+	for i, t := range tokens {
+		if t.Kind != pkg.TokenWhitespace && t.Kind != pkg.TokenComment {
+			sig = append(sig, i)
+		}
+	}
+
+	ddl, perRow := false, false
+	for n, i := range sig {
+		t := tokens[i]
+		if n == 0 && (t.Is("CREATE") || t.Is("ALTER")) {
+			ddl = true
+		}
+		if t.Is("UPDATE") || t.Is("DELETE") || t.Is("SELECT") || t.Is("FROM") || t.Is("WHERE") {
+			perRow = true
+		}
+	}
+
+	replace := make(map[int]string)
+	// funcs holds the function name of every open parenthesis, "" for
+	// plain grouping
+	var funcs []string
+	for n, i := range sig {
+		t := tokens[i]
+		next := func(k int) pkg.Token {
+			if n+k < len(sig) {
+				return tokens[sig[n+k]]
+			}
+			return pkg.Token{}
+		}
+		call := next(1).Kind == pkg.TokenPunct && next(1).Text == "("
+
+		switch {
+		case t.Kind == pkg.TokenPunct && t.Text == "(":
+			name := ""
+			if n > 0 && tokens[sig[n-1]].Kind == pkg.TokenIdent {
+				name = strings.ToLower(tokens[sig[n-1]].Text)
+			}
+			funcs = append(funcs, name)
+		case t.Kind == pkg.TokenPunct && t.Text == ")":
+			if len(funcs) > 0 {
+				funcs = funcs[:len(funcs)-1]
+			}
+
+		case (t.Is("CURRENT_TIMESTAMP") || t.Is("CURRENT_DATE") || t.Is("CURRENT_TIME")) && !call:
+			if ddl {
+				return "", false, fmt.Errorf("%w: %s in schema definition", ErrNonDeterministic, t.Text)
+			}
+			layout := "2006-01-02 15:04:05"
+			if t.Is("CURRENT_DATE") {
+				layout = "2006-01-02"
+			} else if t.Is("CURRENT_TIME") {
+				layout = "15:04:05"
+			}
+			replace[i] = quote(now.Format(layout))
+
+		case t.Kind == pkg.TokenString && localModifiers[strings.ToLower(t.StringValue())] &&
+			len(funcs) > 0 && isTimeFunc(funcs[len(funcs)-1]):
+			return "", false, fmt.Errorf("%w: '%s' depends on the timezone of each replica", ErrNonDeterministic, t.StringValue())
+
+		case t.Kind == pkg.TokenParam && inTimeFunc(funcs):
+			// the value is only known when the statement runs and may be 'now'
+			return "", false, fmt.Errorf("%w: bound parameter %s in a date and time function", ErrNonDeterministic, t.Text)
+
+		case t.Kind == pkg.TokenString && strings.EqualFold(t.StringValue(), "now") &&
+			len(funcs) > 0 && isTimeFunc(funcs[len(funcs)-1]):
+			if ddl {
+				return "", false, fmt.Errorf("%w: 'now' in schema definition", ErrNonDeterministic)
+			}
+			replace[i] = quote(now.Format("2006-01-02 15:04:05.000"))
+
+		case t.Kind == pkg.TokenIdent && call && timeFuncs[strings.ToLower(t.Text)] &&
+			next(2).Kind == pkg.TokenPunct && next(2).Text == ")":
+			// date() and friends default to 'now'
+			if ddl {
+				return "", false, fmt.Errorf("%w: %s() in schema definition", ErrNonDeterministic, t.Text)
+			}
+			replace[sig[n+2]] = quote(now.Format("2006-01-02 15:04:05.000")) + ")"
+
+		case t.Is("random") && call && next(2).Text == ")":
+			if ddl || perRow {
+				return "", false, fmt.Errorf("%w: random() evaluated per row", ErrNonDeterministic)
+			}
+			var buf [8]byte
+			if _, err := rand.Read(buf[:]); err != nil {
+				return "", false, err
+			}
+			v := int64(binary.LittleEndian.Uint64(buf[:]))
+			replace[i] = "(" + strconv.FormatInt(v, 10) + ")"
+			replace[sig[n+1]] = ""
+			replace[sig[n+2]] = ""
+
+		case t.Is("randomblob") && call:
+			if ddl || perRow {
+				return "", false, fmt.Errorf("%w: randomblob() evaluated per row", ErrNonDeterministic)
+			}
+			arg := next(2)
+			size, err := strconv.Atoi(arg.Text)
+			if arg.Kind != pkg.TokenNumber || err != nil || next(3).Text != ")" {
+				return "", false, fmt.Errorf("%w: randomblob() needs an integer literal size", ErrNonDeterministic)
+			}
+			if size < 1 {
+				size = 1
+			}
+			if size > maxRandomBlob {
+				return "", false, fmt.Errorf("randomblob(%d) exceeds %d bytes", size, maxRandomBlob)
+			}
+			buf := make([]byte, size)
+			if _, err := rand.Read(buf); err != nil {
+				return "", false, err
+			}
+			replace[i] = "X'" + strings.ToUpper(hex.EncodeToString(buf)) + "'"
+			for k := 1; k <= 3; k++ {
+				replace[sig[n+k]] = ""
+			}
+		}
+	}
+
+	var out strings.Builder
+	for i, t := range tokens {
+		if text, ok := replace[i]; ok {
+			out.WriteString(text)
+			continue
+		}
+		out.WriteString(t.Text)
+	}
+	return out.String(), len(replace) > 0, nil
+}
+
+// localModifiers convert between UTC and the local time of the process
+// evaluating them
+var localModifiers = map[string]bool{
+	"localtime": true,
+	"utc":       true,
+}
+
+func isTimeFunc(name string) bool {
+	_, ok := timeFuncs[name]
+	return ok
+}
+
+// inTimeFunc reports whether one of the open calls is a date and time
+// function
+func inTimeFunc(funcs []string) bool {
+	for _, name := range funcs {
+		if isTimeFunc(name) {
+			return true
+		}
+	}
+	return false
+}
+
+func quote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
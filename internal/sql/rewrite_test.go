@@ -0,0 +1,76 @@
+package sql
+
+import (
+	"errors"
+	"regexp"
+	"testing"
+	"time"
+)
+
+func TestRewrite(t *testing.T) {
+	now := time.Date(2024, 5, 6, 7, 8, 9, 0, time.UTC)
+	tests := []struct {
+		sql  string
+		want string
+	}{
+		{"INSERT INTO t VALUES (CURRENT_TIMESTAMP)", "INSERT INTO t VALUES ('2024-05-06 07:08:09')"},
+		{"INSERT INTO t VALUES (current_date, CURRENT_TIME)", "INSERT INTO t VALUES ('2024-05-06', '07:08:09')"},
+		{"INSERT INTO t VALUES (datetime('now', '+1 day'))", "INSERT INTO t VALUES (datetime('2024-05-06 07:08:09.000', '+1 day'))"},
+		{"INSERT INTO t VALUES (date())", "INSERT INTO t VALUES (date('2024-05-06 07:08:09.000'))"},
+		{"UPDATE t SET at = strftime('%s', 'now')", "UPDATE t SET at = strftime('%s', '2024-05-06 07:08:09.000')"},
+		// 'now' outside the time functions is just text
+		{"INSERT INTO t VALUES ('now', 'CURRENT_TIMESTAMP')", "INSERT INTO t VALUES ('now', 'CURRENT_TIMESTAMP')"},
+		{"UPDATE t SET n = n + 1 -- random()", "UPDATE t SET n = n + 1 -- random()"},
+		// a trigger body is one statement with its CREATE
+		{
+			"CREATE TRIGGER tr AFTER INSERT ON t BEGIN UPDATE x SET a = 1; INSERT INTO log VALUES (new.id); END; INSERT INTO t VALUES (date())",
+			"CREATE TRIGGER tr AFTER INSERT ON t BEGIN UPDATE x SET a = 1; INSERT INTO log VALUES (new.id); END; INSERT INTO t VALUES (date('2024-05-06 07:08:09.000'))",
+		},
+		{"INSERT INTO t VALUES (?, datetime('2024-01-01', '+1 day'))", "INSERT INTO t VALUES (?, datetime('2024-01-01', '+1 day'))"},
+	}
+	for _, tt := range tests {
+		got, err := Rewrite(tt.sql, now)
+		if err != nil {
+			t.Errorf("Rewrite(%q) failed: %v", tt.sql, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("Rewrite(%q) = %q, want %q", tt.sql, got, tt.want)
+		}
+	}
+}
+
+func TestRewriteRandom(t *testing.T) {
+	// statements are checked one by one
+	if _, err := Rewrite("INSERT INTO t VALUES (random()); SELECT 1", time.Now()); err != nil {
+		t.Fatalf("Rewrite failed: %v", err)
+	}
+
+	got, err := Rewrite("INSERT INTO t VALUES (random(), randomblob(4))", time.Now())
+	if err != nil {
+		t.Fatalf("Rewrite failed: %v", err)
+	}
+	if !regexp.MustCompile(`^INSERT INTO t VALUES \(\(-?\d+\), X'[0-9A-F]{8}'\)$`).MatchString(got) {
+		t.Fatalf("unexpected rewrite %q", got)
+	}
+}
+
+func TestRewriteRejects(t *testing.T) {
+	for _, sql := range []string{
+		"UPDATE t SET n = random()",
+		"DELETE FROM t WHERE random() > 0",
+		"INSERT INTO t SELECT randomblob(4) FROM u",
+		"INSERT INTO t VALUES (randomblob(n))",
+		"CREATE TABLE t (at TEXT DEFAULT CURRENT_TIMESTAMP)",
+		"ALTER TABLE t ADD COLUMN at TEXT DEFAULT (datetime('now'))",
+		"CREATE TRIGGER tr AFTER INSERT ON t BEGIN UPDATE x SET a = 1; INSERT INTO log VALUES (datetime('now')); END",
+		"INSERT INTO t VALUES (datetime('2024-01-01 00:00:00', 'localtime'))",
+		"INSERT INTO t VALUES (date('now', 'UTC'))",
+		"INSERT INTO t VALUES (datetime(?))",
+		"INSERT INTO t VALUES (strftime('%s', :at, '+1 day'))",
+	} {
+		if got, err := Rewrite(sql, time.Now()); !errors.Is(err, ErrNonDeterministic) {
+			t.Errorf("Rewrite(%q) = %q, %v; want ErrNonDeterministic", sql, got, err)
+		}
+	}
+}
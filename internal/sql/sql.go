@@ -3,22 +3,112 @@ package sql
 import (
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
 
 	"github.com/hashicorp/raft"
-	_ "github.com/mattn/go-sqlite3"
+	"github.com/mattn/go-sqlite3"
 )
 
 type SQLFSM struct {
 	name            string
 	DB              *sql.DB
 	AppliedCommands []Command
+	mu              sync.RWMutex
+	// OnRestore, when set, is called after a snapshot replaced the file
+	OnRestore func()
+	// OnSchemaChange, when set, is called after a command changed the
+	// schema, so statements prepared elsewhere can be dropped
+	OnSchemaChange func()
+	// schemaVersion is the last PRAGMA schema_version seen by Apply
+	schemaVersion int64
 }
 
 type Command struct {
-	SQL string
+	SQL    string
+	Params []Param `json:",omitempty"`
+	// Statements, when set, run in one transaction instead of SQL
+	Statements []Statement `json:",omitempty"`
+	// ContinueOnError keeps running the transaction after a failed
+	// statement and commits the ones that succeeded
+	ContinueOnError bool `json:",omitempty"`
+}
+
+// Statement is one statement of a transaction
+type Statement struct {
+	SQL    string
+	Params []Param `json:",omitempty"`
+}
+
+// StatementResult is the outcome of one statement
+type StatementResult struct {
+	RowsAffected int64 `json:"rows_affected"`
+	LastInsertID int64 `json:"last_insert_id"`
+	// Code is the SQLite extended result code of a failed statement
+	Code    int     `json:"code,omitempty"`
+	Error   string  `json:"error,omitempty"`
+	Elapsed float64 `json:"elapsed_ms"`
+}
+
+// ApplyResult is what Apply returns through ApplyFuture.Response
+type ApplyResult struct {
+	Results []StatementResult `json:"results"`
+	// Committed is false when the statement failed or the transaction
+	// was rolled back
+	Committed bool    `json:"committed"`
+	Elapsed   float64 `json:"elapsed_ms"`
+}
+
+// Err returns the first statement error of the result, if any
+func (r *ApplyResult) Err() error {
+	for _, res := range r.Results {
+		if res.Error != "" {
+			return &StatementError{Code: res.Code, Message: res.Error}
+		}
+	}
+	return nil
+}
+
+// StatementError is a failed statement reported by Apply
+type StatementError struct {
+	Code    int
+	Message string
+}
+
+func (e *StatementError) Error() string {
+	return e.Message
+}
+
+func newStatementResult(res sql.Result, err error, start time.Time) StatementResult {
+	out := StatementResult{Elapsed: elapsedMs(start)}
+	if err != nil {
+		out.Code = errorCode(err)
+		out.Error = err.Error()
+		return out
+	}
+	out.RowsAffected, _ = res.RowsAffected()
+	out.LastInsertID, _ = res.LastInsertId()
+	return out
+}
+
+// errorCode maps err to a SQLite extended result code, SQLITE_ERROR for
+// errors that did not come from SQLite
+func errorCode(err error) int {
+	var sqliteErr sqlite3.Error
+	if errors.As(err, &sqliteErr) {
+		return int(sqliteErr.ExtendedCode)
+	}
+	return int(sqlite3.ErrError)
+}
+
+func elapsedMs(start time.Time) float64 {
+	return float64(time.Since(start).Microseconds()) / 1000
 }
 
 func NewSQLFSM(name string) *SQLFSM {
@@ -33,40 +123,210 @@ func NewSQLFSM(name string) *SQLFSM {
 }
 
 func (f *SQLFSM) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
 	return f.DB.Close()
 }
 
+// Apply runs a command and returns its *ApplyResult
 func (f *SQLFSM) Apply(l *raft.Log) interface{} {
+	start := time.Now()
 	// sqlStmt := string(l.Data)
 	var cmd Command
 	if err := json.Unmarshal(l.Data, &cmd); err != nil {
 		log.Printf("failed to unmarshal command: %v", err)
-		return nil
+		return &ApplyResult{
+			Results: []StatementResult{newStatementResult(nil, err, start)},
+			Elapsed: elapsedMs(start),
+		}
 	}
 
-	sqlStmt := cmd.SQL
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	if len(cmd.Statements) > 0 {
+		result := f.applyTx(cmd)
+		f.checkSchema()
+		result.Elapsed = elapsedMs(start)
+		f.AppliedCommands = append(f.AppliedCommands, cmd)
+		return result
+	}
 
-	fmt.Println("Applying SQL:", sqlStmt)
-	_, err := f.DB.Exec(sqlStmt)
+	sqlStmt := cmd.SQL
+	res, err := f.DB.Exec(sqlStmt, Args(cmd.Params)...)
 	if err != nil {
 		log.Printf("SQL Exec error: %v", err)
 	}
-	f.AppliedCommands = append(f.AppliedCommands, Command{SQL: sqlStmt})
-	return nil
+	f.checkSchema()
+	f.AppliedCommands = append(f.AppliedCommands, cmd)
+	return &ApplyResult{
+		Results:   []StatementResult{newStatementResult(res, err, start)},
+		Committed: err == nil,
+		Elapsed:   elapsedMs(start),
+	}
+}
+
+// checkSchema calls OnSchemaChange when PRAGMA schema_version moved since
+// the last command. Reading it is cheap, so it is checked after every
+// command instead of guessing which statements are DDL.
+func (f *SQLFSM) checkSchema() {
+	version, err := f.readSchemaVersion()
+	if err != nil {
+		log.Printf("failed to read schema version: %v", err)
+		return
+	}
+	if version == f.schemaVersion {
+		return
+	}
+	f.schemaVersion = version
+	if f.OnSchemaChange != nil {
+		f.OnSchemaChange()
+	}
+}
+
+func (f *SQLFSM) readSchemaVersion() (int64, error) {
+	var version int64
+	err := f.DB.QueryRow("PRAGMA schema_version").Scan(&version)
+	return version, err
+}
+
+// applyTx runs the statements of cmd in one transaction. Without
+// ContinueOnError the first failure rolls everything back.
+func (f *SQLFSM) applyTx(cmd Command) *ApplyResult {
+	result := &ApplyResult{Results: make([]StatementResult, 0, len(cmd.Statements))}
+	start := time.Now()
+	tx, err := f.DB.Begin()
+	if err != nil {
+		log.Printf("SQL Begin error: %v", err)
+		result.Results = append(result.Results, newStatementResult(nil, err, start))
+		return result
+	}
+
+	for _, stmt := range cmd.Statements {
+		start := time.Now()
+		res, err := tx.Exec(stmt.SQL, Args(stmt.Params)...)
+		result.Results = append(result.Results, newStatementResult(res, err, start))
+		if err != nil {
+			log.Printf("SQL Exec error: %v", err)
+			if !cmd.ContinueOnError {
+				if err := tx.Rollback(); err != nil {
+					log.Printf("SQL Rollback error: %v", err)
+				}
+				return result
+			}
+		}
+	}
+
+	start = time.Now()
+	if err := tx.Commit(); err != nil {
+		log.Printf("SQL Commit error: %v", err)
+		result.Results = append(result.Results, newStatementResult(nil, err, start))
+		return result
+	}
+	result.Committed = true
+	return result
 }
 
+// Snapshot writes a consistent copy of the database with VACUUM INTO.
+// Raft calls Snapshot on the FSM goroutine, so no Apply runs while the
+// copy is taken; Persist then streams the file without blocking writes.
 func (f *SQLFSM) Snapshot() (raft.FSMSnapshot, error) {
-	return &NoopSnapshot{}, nil
+	tmp, err := os.CreateTemp(filepath.Dir(f.name), ".snapshot-*.sqlite")
+	if err != nil {
+		return nil, err
+	}
+	path := tmp.Name()
+	tmp.Close()
+	// VACUUM INTO refuses to overwrite an existing file
+	if err := os.Remove(path); err != nil {
+		return nil, err
+	}
+
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	if _, err := f.DB.Exec("VACUUM INTO ?", path); err != nil {
+		os.Remove(path)
+		return nil, fmt.Errorf("snapshot %s: %w", f.name, err)
+	}
+	return &SQLiteSnapshot{path: path}, nil
 }
+
+// Restore replaces the database with the snapshot read from rc. The data
+// is written next to the database file first and renamed over it, so a
+// crash mid-restore never leaves a half-written database behind.
 func (f *SQLFSM) Restore(rc io.ReadCloser) error {
+	defer rc.Close()
+
+	tmp, err := os.CreateTemp(filepath.Dir(f.name), ".restore-*.sqlite")
+	if err != nil {
+		return err
+	}
+	tmpPath := tmp.Name()
+	if _, err := io.Copy(tmp, rc); err != nil {
+		tmp.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.DB.Close(); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	// stale WAL or journal files would be replayed on top of the snapshot
+	for _, suffix := range []string{"-wal", "-shm", "-journal"} {
+		os.Remove(f.name + suffix)
+	}
+	if err := os.Rename(tmpPath, f.name); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	db, err := sql.Open("sqlite3", f.name)
+	if err != nil {
+		return err
+	}
+	f.DB = db
+	f.AppliedCommands = nil
+	// readers reopen the file after a restore, there is nothing to
+	// invalidate
+	if f.schemaVersion, err = f.readSchemaVersion(); err != nil {
+		return err
+	}
+	if f.OnRestore != nil {
+		f.OnRestore()
+	}
 	return nil
 }
 
-type NoopSnapshot struct{}
+// SQLiteSnapshot is a point-in-time copy of the database waiting to be
+// persisted to a raft.SnapshotSink.
+type SQLiteSnapshot struct {
+	path string
+}
 
-func (s *NoopSnapshot) Persist(sink raft.SnapshotSink) error {
-	sink.Cancel()
-	return nil
+func (s *SQLiteSnapshot) Persist(sink raft.SnapshotSink) error {
+	file, err := os.Open(s.path)
+	if err != nil {
+		sink.Cancel()
+		return err
+	}
+	defer file.Close()
+	if _, err := io.Copy(sink, file); err != nil {
+		sink.Cancel()
+		return err
+	}
+	return sink.Close()
 }
 
-func (s *NoopSnapshot) Release() {}
+func (s *SQLiteSnapshot) Release() {
+	os.Remove(s.path)
+}
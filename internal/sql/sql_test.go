@@ -1,5 +1,78 @@
 package sql
 
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"path/filepath"
+	"testing"
+
+	"github.com/hashicorp/raft"
+	"github.com/mattn/go-sqlite3"
+)
+
+type bufferSink struct {
+	bytes.Buffer
+	canceled bool
+}
+
+func (s *bufferSink) ID() string    { return "test" }
+func (s *bufferSink) Close() error  { return nil }
+func (s *bufferSink) Cancel() error { s.canceled = true; return nil }
+
+func applySQL(t *testing.T, f *SQLFSM, stmt string) {
+	t.Helper()
+	data, err := json.Marshal(Command{SQL: stmt})
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Apply(&raft.Log{Data: data})
+}
+
+func TestSnapshotRestore(t *testing.T) {
+	dir := t.TempDir()
+	src := NewSQLFSM(filepath.Join(dir, "src.db"))
+	defer src.Close()
+	applySQL(t, src, "CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT)")
+	applySQL(t, src, "INSERT INTO users (name) VALUES ('Alice'), ('Bob')")
+
+	snap, err := src.Snapshot()
+	if err != nil {
+		t.Fatalf("Snapshot failed: %v", err)
+	}
+	defer snap.Release()
+
+	// writes after the snapshot must not leak into it
+	applySQL(t, src, "INSERT INTO users (name) VALUES ('Charlie')")
+
+	sink := &bufferSink{}
+	if err := snap.Persist(sink); err != nil {
+		t.Fatalf("Persist failed: %v", err)
+	}
+	if sink.canceled {
+		t.Fatal("sink should not be canceled")
+	}
+
+	dst := NewSQLFSM(filepath.Join(dir, "dst.db"))
+	defer dst.Close()
+	applySQL(t, dst, "CREATE TABLE other (id INTEGER)")
+	if err := dst.Restore(io.NopCloser(&sink.Buffer)); err != nil {
+		t.Fatalf("Restore failed: %v", err)
+	}
+
+	var count int
+	if err := dst.DB.QueryRow("SELECT COUNT(*) FROM users").Scan(&count); err != nil {
+		t.Fatalf("QueryRow failed: %v", err)
+	}
+	if count != 2 {
+		t.Fatalf("expected 2 rows, got %d", count)
+	}
+	if err := dst.DB.QueryRow("SELECT COUNT(*) FROM other").Scan(&count); err == nil {
+		t.Fatal("expected table from before restore to be gone")
+	}
+}
+
 // import (
 // 	"testing"
 // )
@@ -60,3 +133,110 @@ package sql
 // 		t.Fatal("Expected nil result for invalid query")
 // 	}
 // }
+
+func applyCommand(t *testing.T, f *SQLFSM, cmd Command) interface{} {
+	t.Helper()
+	data, err := json.Marshal(cmd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return f.Apply(&raft.Log{Data: data})
+}
+
+func countRows(t *testing.T, f *SQLFSM, table string) int {
+	t.Helper()
+	var count int
+	if err := f.DB.QueryRow("SELECT COUNT(*) FROM " + table).Scan(&count); err != nil {
+		t.Fatalf("QueryRow failed: %v", err)
+	}
+	return count
+}
+
+func TestApplyTransaction(t *testing.T) {
+	f := NewSQLFSM(filepath.Join(t.TempDir(), "tx.db"))
+	defer f.Close()
+	applySQL(t, f, "CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT NOT NULL)")
+
+	stmts := []Statement{
+		{SQL: "INSERT INTO users (name) VALUES (?)", Params: []Param{{Type: ParamText, Value: "Alice"}}},
+		{SQL: "INSERT INTO users (name) VALUES (NULL)"},
+		{SQL: "INSERT INTO users (name) VALUES ('Bob')"},
+	}
+
+	res, ok := applyCommand(t, f, Command{Statements: stmts}).(*ApplyResult)
+	if !ok {
+		t.Fatal("expected *ApplyResult")
+	}
+	if res.Committed {
+		t.Fatal("expected rollback")
+	}
+	if len(res.Results) != 2 || res.Results[1].Error == "" {
+		t.Fatalf("expected failure at the second statement, got %+v", res.Results)
+	}
+	if n := countRows(t, f, "users"); n != 0 {
+		t.Fatalf("expected no rows after rollback, got %d", n)
+	}
+
+	res = applyCommand(t, f, Command{Statements: stmts, ContinueOnError: true}).(*ApplyResult)
+	if !res.Committed {
+		t.Fatal("expected commit")
+	}
+	if len(res.Results) != 3 || res.Results[1].Error == "" || res.Results[2].LastInsertID != 2 {
+		t.Fatalf("unexpected results %+v", res.Results)
+	}
+	if n := countRows(t, f, "users"); n != 2 {
+		t.Fatalf("expected 2 rows, got %d", n)
+	}
+}
+
+func TestApplyResult(t *testing.T) {
+	f := NewSQLFSM(filepath.Join(t.TempDir(), "result.db"))
+	defer f.Close()
+	applySQL(t, f, "CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT UNIQUE)")
+
+	res := applyCommand(t, f, Command{SQL: "INSERT INTO users (name) VALUES ('Alice'), ('Bob')"}).(*ApplyResult)
+	if !res.Committed || res.Err() != nil {
+		t.Fatalf("expected success, got %+v", res)
+	}
+	if got := res.Results[0]; got.RowsAffected != 2 || got.LastInsertID != 2 {
+		t.Fatalf("unexpected result %+v", got)
+	}
+
+	res = applyCommand(t, f, Command{SQL: "INSERT INTO users (name) VALUES ('Alice')"}).(*ApplyResult)
+	if res.Committed {
+		t.Fatal("expected failure")
+	}
+	var stmtErr *StatementError
+	if !errors.As(res.Err(), &stmtErr) {
+		t.Fatalf("expected *StatementError, got %v", res.Err())
+	}
+	if stmtErr.Code != int(sqlite3.ErrConstraintUnique) {
+		t.Fatalf("expected SQLITE_CONSTRAINT_UNIQUE, got %d", stmtErr.Code)
+	}
+
+	res = f.Apply(&raft.Log{Data: []byte("not json")}).(*ApplyResult)
+	if res.Committed || res.Err() == nil {
+		t.Fatal("expected malformed command to fail")
+	}
+}
+
+func TestOnSchemaChange(t *testing.T) {
+	f := NewSQLFSM(filepath.Join(t.TempDir(), "schema.db"))
+	defer f.Close()
+	changes := 0
+	f.OnSchemaChange = func() { changes++ }
+
+	applySQL(t, f, "CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT)")
+	applySQL(t, f, "INSERT INTO users (name) VALUES ('Alice')")
+	if changes != 1 {
+		t.Fatalf("expected 1 schema change, got %d", changes)
+	}
+	applyCommand(t, f, Command{Statements: []Statement{
+		{SQL: "CREATE INDEX users_name ON users (name)"},
+		{SQL: "INSERT INTO users (name) VALUES ('Bob')"},
+	}})
+	applySQL(t, f, "CREATE TABLE users (id INTEGER)")
+	if changes != 2 {
+		t.Fatalf("expected 2 schema changes, got %d", changes)
+	}
+}
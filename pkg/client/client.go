@@ -0,0 +1,415 @@
+// Package client is a Go client for the HTTP API of an rflite cluster.
+// It finds the leader of each database through /status, sends writes and
+// leader reads there, and retries on other nodes when a node is down or
+// lost its leadership.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Level is the consistency of a read, the level parameter of /query
+type Level string
+
+const (
+	// LevelNone reads the local copy of any node, which may be stale
+	LevelNone Level = "none"
+	// LevelWeak reads on the node that believes it is the leader
+	LevelWeak Level = "weak"
+	// LevelStrong reads on the leader after it confirmed its leadership
+	// and applied every committed write
+	LevelStrong Level = "strong"
+)
+
+// Config configures a Client
+type Config struct {
+	// Nodes are the HTTP addresses of cluster members, host:port or a
+	// URL. The client only talks to these nodes.
+	Nodes []string
+	// Level is the consistency of Query, LevelNone when empty
+	Level Level
+	// Timeout bounds every call and is passed to the server as the
+	// timeout of the request, 0 leaves calls bounded by their context
+	Timeout time.Duration
+	// Retries is the number of retries of a failed request, 3 when 0 and
+	// none when negative
+	Retries int
+	// Backoff is the wait before the first retry, doubled for every
+	// further one. Defaults to 100ms.
+	Backoff time.Duration
+	// HTTPClient sends the requests, http.DefaultClient when nil
+	HTTPClient *http.Client
+}
+
+// Client sends queries and writes to a cluster. It is safe for concurrent
+// use.
+type Client struct {
+	cfg   Config
+	nodes []string
+	level Level
+	// leaders is shared with the clients returned by WithLevel
+	leaders *leaderCache
+}
+
+// leaderCache remembers the node to send the writes of each database to
+type leaderCache struct {
+	mu    sync.Mutex
+	nodes map[string]string
+	// next picks the node of the next read that any node can serve
+	next int
+}
+
+// Statement is one statement of a Batch
+type Statement struct {
+	SQL  string
+	Args []interface{}
+}
+
+// ExecResult is the outcome of one write
+type ExecResult struct {
+	RowsAffected int64 `json:"rows_affected"`
+	LastInsertID int64 `json:"last_insert_id"`
+}
+
+// Rows is the result of a query. Values are int64, float64, string or
+// nil; blobs come back as base64 strings.
+type Rows struct {
+	Columns []string
+	// Types are the declared types of the columns, empty for
+	// expressions
+	Types  []string
+	Values [][]interface{}
+}
+
+// New creates a client for the cluster made of cfg.Nodes
+func New(cfg Config) (*Client, error) {
+	if len(cfg.Nodes) == 0 {
+		return nil, errors.New("rflite: no nodes")
+	}
+	nodes := make([]string, len(cfg.Nodes))
+	for i, node := range cfg.Nodes {
+		if !strings.Contains(node, "://") {
+			node = "http://" + node
+		}
+		u, err := url.Parse(node)
+		if err != nil {
+			return nil, fmt.Errorf("rflite: invalid node %q: %w", cfg.Nodes[i], err)
+		}
+		nodes[i] = strings.TrimSuffix(u.String(), "/")
+	}
+	if cfg.Retries == 0 {
+		cfg.Retries = 3
+	}
+	if cfg.Backoff <= 0 {
+		cfg.Backoff = 100 * time.Millisecond
+	}
+	if cfg.HTTPClient == nil {
+		cfg.HTTPClient = http.DefaultClient
+	}
+	level := cfg.Level
+	if level == "" {
+		level = LevelNone
+	}
+	return &Client{
+		cfg:     cfg,
+		nodes:   nodes,
+		level:   level,
+		leaders: &leaderCache{nodes: make(map[string]string)},
+	}, nil
+}
+
+// WithLevel returns a client that reads at level and shares everything
+// else with c
+func (c *Client) WithLevel(level Level) *Client {
+	clone := *c
+	clone.level = level
+	return &clone
+}
+
+// Query runs a read-only statement on db
+func (c *Client) Query(ctx context.Context, db, query string, args ...interface{}) (*Rows, error) {
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+
+	body, err := statementsBody([]Statement{{SQL: query, Args: args}})
+	if err != nil {
+		return nil, err
+	}
+	params := url.Values{"format": {"columnar"}}
+	if c.level != LevelNone {
+		params.Set("level", string(c.level))
+	}
+	resp, err := c.do(ctx, db, c.level != LevelNone, true, "/query", params, body)
+	var results []struct {
+		Status bool            `json:"status"`
+		Error  string          `json:"error"`
+		Result json.RawMessage `json:"result"`
+	}
+	if resp != nil && len(resp.Result) > 0 {
+		if jsonErr := json.Unmarshal(resp.Result, &results); jsonErr != nil && err == nil {
+			return nil, fmt.Errorf("rflite: invalid response: %w", jsonErr)
+		}
+	}
+	if len(results) == 1 && !results[0].Status {
+		return nil, &StatementError{Message: results[0].Error}
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(results) != 1 {
+		return nil, fmt.Errorf("rflite: got %d results for 1 statement", len(results))
+	}
+
+	var out struct {
+		Columns []string            `json:"columns"`
+		Types   []string            `json:"types"`
+		Values  [][]json.RawMessage `json:"values"`
+	}
+	if err := json.Unmarshal(results[0].Result, &out); err != nil {
+		return nil, fmt.Errorf("rflite: invalid response: %w", err)
+	}
+	rows := &Rows{Columns: out.Columns, Types: out.Types, Values: make([][]interface{}, len(out.Values))}
+	for i, raw := range out.Values {
+		row := make([]interface{}, len(raw))
+		for j, v := range raw {
+			if row[j], err = decodeValue(v); err != nil {
+				return nil, fmt.Errorf("rflite: invalid response: %w", err)
+			}
+		}
+		rows.Values[i] = row
+	}
+	return rows, nil
+}
+
+// Exec applies a write to db
+func (c *Client) Exec(ctx context.Context, db, query string, args ...interface{}) (*ExecResult, error) {
+	results, err := c.exec(ctx, db, []Statement{{SQL: query, Args: args}})
+	if err != nil {
+		return nil, err
+	}
+	return &results[0], nil
+}
+
+// Batch applies writes to db in one transaction, nothing is applied when
+// one of them fails
+func (c *Client) Batch(ctx context.Context, db string, stmts []Statement) ([]ExecResult, error) {
+	if len(stmts) == 0 {
+		return nil, nil
+	}
+	return c.exec(ctx, db, stmts)
+}
+
+func (c *Client) exec(ctx context.Context, db string, stmts []Statement) ([]ExecResult, error) {
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+
+	body, err := statementsBody(stmts)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.do(ctx, db, true, false, "/exec", nil, body)
+	var result struct {
+		Results []struct {
+			ExecResult
+			Code  int    `json:"code"`
+			Error string `json:"error"`
+		} `json:"results"`
+		Committed bool `json:"committed"`
+	}
+	if resp != nil && len(resp.Result) > 0 {
+		if jsonErr := json.Unmarshal(resp.Result, &result); jsonErr != nil && err == nil {
+			return nil, fmt.Errorf("rflite: invalid response: %w", jsonErr)
+		}
+	}
+	for i, res := range result.Results {
+		if res.Error != "" {
+			return nil, &StatementError{Index: i, Code: res.Code, Message: res.Error}
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !result.Committed || len(result.Results) < len(stmts) {
+		return nil, fmt.Errorf("rflite: write was not committed")
+	}
+	out := make([]ExecResult, len(stmts))
+	for i := range out {
+		out[i] = result.Results[i].ExecResult
+	}
+	return out, nil
+}
+
+func (c *Client) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if c.cfg.Timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, c.cfg.Timeout)
+}
+
+// response is the envelope of every API answer
+type response struct {
+	Status  bool            `json:"status"`
+	Error   string          `json:"error"`
+	Message string          `json:"message"`
+	Code    string          `json:"code"`
+	Result  json.RawMessage `json:"result"`
+}
+
+// do sends a request for db to the leader, or to any node when leader is
+// false, retrying with backoff while the cluster is unavailable. A
+// request that is not idempotent is only retried when it never left the
+// client or a node refused it as not leader, so a write is never applied
+// twice. A refused request returns its response together with an *Error.
+func (c *Client) do(ctx context.Context, db string, leader, idempotent bool, path string, params url.Values, body []byte) (*response, error) {
+	if c.cfg.Timeout > 0 {
+		if params == nil {
+			params = url.Values{}
+		}
+		params.Set("timeout", c.cfg.Timeout.String())
+	}
+	backoff := c.cfg.Backoff
+	for attempt := 0; ; attempt++ {
+		node := c.pick(ctx, db, leader)
+		resp, err := c.send(ctx, node, "/db/"+url.PathEscape(db)+path, params, body)
+
+		var apiErr *Error
+		retry := false
+		switch {
+		case err != nil && ctx.Err() != nil:
+			return resp, fmt.Errorf("%w: %w", ErrTimeout, ctx.Err())
+		case errors.As(err, &apiErr):
+			retry = apiErr.retryable(idempotent)
+		case err != nil:
+			retry = idempotent || isDialError(err)
+		}
+		if !retry {
+			return resp, err
+		}
+		c.forgetLeader(db, node)
+		if attempt >= c.cfg.Retries {
+			return resp, err
+		}
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return resp, fmt.Errorf("%w: %w", ErrTimeout, ctx.Err())
+		}
+		backoff *= 2
+	}
+}
+
+// send posts body to one node and decodes the answer
+func (c *Client) send(ctx context.Context, node, path string, params url.Values, body []byte) (*response, error) {
+	target := node + path
+	if len(params) > 0 {
+		target += "?" + params.Encode()
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, target, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	httpResp, err := c.cfg.HTTPClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer httpResp.Body.Close()
+
+	var resp response
+	if err := json.NewDecoder(httpResp.Body).Decode(&resp); err != nil {
+		if httpResp.StatusCode >= 300 {
+			return nil, &Error{StatusCode: httpResp.StatusCode, Message: httpResp.Status}
+		}
+		return nil, fmt.Errorf("rflite: invalid response from %s: %w", node, err)
+	}
+	if httpResp.StatusCode >= 300 {
+		msg := resp.Error
+		if msg == "" {
+			msg = resp.Message
+		}
+		return &resp, &Error{StatusCode: httpResp.StatusCode, Code: resp.Code, Message: msg}
+	}
+	return &resp, nil
+}
+
+// pick returns the node to send a request for db to. Any node serves a
+// read at LevelNone; the leader is looked up through /status, and when
+// none of the configured nodes leads, any of them forwards to it.
+func (c *Client) pick(ctx context.Context, db string, leader bool) string {
+	c.leaders.mu.Lock()
+	if !leader {
+		node := c.nodes[c.leaders.next%len(c.nodes)]
+		c.leaders.next++
+		c.leaders.mu.Unlock()
+		return node
+	}
+	node, ok := c.leaders.nodes[db]
+	c.leaders.mu.Unlock()
+	if ok {
+		return node
+	}
+
+	node = c.discoverLeader(ctx, db)
+	c.leaders.mu.Lock()
+	defer c.leaders.mu.Unlock()
+	if node == "" {
+		node = c.nodes[c.leaders.next%len(c.nodes)]
+		c.leaders.next++
+	}
+	c.leaders.nodes[db] = node
+	return node
+}
+
+// forgetLeader drops node as the leader of db after it failed, so the
+// next attempt looks the leader up again
+func (c *Client) forgetLeader(db, node string) {
+	c.leaders.mu.Lock()
+	defer c.leaders.mu.Unlock()
+	if c.leaders.nodes[db] == node {
+		delete(c.leaders.nodes, db)
+	}
+}
+
+// discoverLeader asks the nodes for their /status and returns the one
+// that leads db, or "" when none of them does
+func (c *Client) discoverLeader(ctx context.Context, db string) string {
+	for _, node := range c.nodes {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, node+"/status", nil)
+		if err != nil {
+			continue
+		}
+		resp, err := c.cfg.HTTPClient.Do(req)
+		if err != nil {
+			continue
+		}
+		var status struct {
+			Result struct {
+				Status map[string]struct {
+					State string `json:"state"`
+				} `json:"status"`
+			} `json:"result"`
+		}
+		err = json.NewDecoder(resp.Body).Decode(&status)
+		resp.Body.Close()
+		if err == nil && status.Result.Status[db].State == "Leader" {
+			return node
+		}
+	}
+	return ""
+}
+
+// isDialError reports whether err happened before the request was sent
+func isDialError(err error) bool {
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && opErr.Op == "dial"
+}
@@ -0,0 +1,256 @@
+package client
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeNode answers /status with its state for db1 and runs handler for
+// everything else
+type fakeNode struct {
+	mu      sync.Mutex
+	state   string
+	handler http.HandlerFunc
+	hits    int
+}
+
+func newFakeNode(t *testing.T, state string, handler http.HandlerFunc) (*fakeNode, *httptest.Server) {
+	t.Helper()
+	n := &fakeNode{state: state, handler: handler}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n.mu.Lock()
+		state, handler := n.state, n.handler
+		if r.URL.Path != "/status" {
+			n.hits++
+		}
+		n.mu.Unlock()
+		if r.URL.Path == "/status" {
+			writeJSON(w, 200, map[string]interface{}{"status": true, "result": map[string]interface{}{
+				"status": map[string]interface{}{"db1": map[string]string{"state": state}},
+			}})
+			return
+		}
+		handler(w, r)
+	}))
+	t.Cleanup(srv.Close)
+	return n, srv
+}
+
+func (n *fakeNode) set(state string, handler http.HandlerFunc) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.state, n.handler = state, handler
+}
+
+func (n *fakeNode) requests() int {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.hits
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
+
+func notLeader(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, 503, map[string]interface{}{"status": false, "error": "not leader", "code": "not_leader"})
+}
+
+func committed(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, 200, map[string]interface{}{"status": true, "result": map[string]interface{}{
+		"results":   []map[string]interface{}{{"rows_affected": 1, "last_insert_id": 7}},
+		"committed": true,
+	}})
+}
+
+func TestExecFindsLeader(t *testing.T) {
+	follower, followerSrv := newFakeNode(t, "Follower", notLeader)
+	leader, leaderSrv := newFakeNode(t, "Leader", committed)
+	c, err := New(Config{Nodes: []string{followerSrv.URL, leaderSrv.URL}, Backoff: time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := c.Exec(context.Background(), "db1", "INSERT INTO t VALUES (?)", 1)
+	if err != nil {
+		t.Fatalf("Exec failed: %v", err)
+	}
+	if res.RowsAffected != 1 || res.LastInsertID != 7 {
+		t.Fatalf("got %+v", res)
+	}
+	if follower.requests() != 0 || leader.requests() != 1 {
+		t.Fatalf("got %d requests on the follower and %d on the leader", follower.requests(), leader.requests())
+	}
+
+	// leadership moves: the old leader refuses, the client finds the new one
+	leader.set("Follower", notLeader)
+	follower.set("Leader", committed)
+	if _, err := c.Exec(context.Background(), "db1", "INSERT INTO t VALUES (2)"); err != nil {
+		t.Fatalf("Exec after a leader change failed: %v", err)
+	}
+	if follower.requests() != 1 {
+		t.Fatalf("got %d requests on the new leader, want 1", follower.requests())
+	}
+}
+
+func TestRetryGivesUp(t *testing.T) {
+	_, srv := newFakeNode(t, "Follower", notLeader)
+	c, err := New(Config{Nodes: []string{srv.URL}, Retries: 2, Backoff: time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = c.Exec(context.Background(), "db1", "INSERT INTO t VALUES (1)")
+	if !errors.Is(err, ErrNotLeader) || !errors.Is(err, ErrUnavailable) {
+		t.Fatalf("got %v, want a not leader error", err)
+	}
+}
+
+func TestWriteNotRetriedAfterApply(t *testing.T) {
+	for _, code := range []int{502, 503} {
+		var mu sync.Mutex
+		applied := 0
+		_, srv := newFakeNode(t, "Leader", func(w http.ResponseWriter, r *http.Request) {
+			mu.Lock()
+			applied++
+			mu.Unlock()
+			// the write went through, but the answer says otherwise
+			writeJSON(w, code, map[string]interface{}{"status": false, "error": "server busy"})
+		})
+		c, err := New(Config{Nodes: []string{srv.URL}, Backoff: time.Millisecond})
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = c.Exec(context.Background(), "db1", "INSERT INTO t VALUES (1)")
+		if !errors.Is(err, ErrUnavailable) {
+			t.Fatalf("%d: got %v, want ErrUnavailable", code, err)
+		}
+		mu.Lock()
+		if applied != 1 {
+			t.Errorf("%d: write sent %d times, want 1", code, applied)
+		}
+		mu.Unlock()
+	}
+
+	_, srv := newFakeNode(t, "Leader", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, 500, map[string]interface{}{"status": false, "message": "DB db1: leadership lost, the command may have been applied", "code": "leadership_lost"})
+	})
+	c, err := New(Config{Nodes: []string{srv.URL}, Backoff: time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Exec(context.Background(), "db1", "INSERT INTO t VALUES (1)"); !errors.Is(err, ErrOutcomeUnknown) {
+		t.Fatalf("got %v, want ErrOutcomeUnknown", err)
+	}
+}
+
+func TestQuerySkipsDeadNode(t *testing.T) {
+	dead := httptest.NewServer(http.NotFoundHandler())
+	dead.Close()
+	var got map[string]interface{}
+	_, srv := newFakeNode(t, "Follower", func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&got)
+		if r.URL.Query().Get("format") != "columnar" || r.URL.Query().Get("timeout") != "5s" {
+			t.Errorf("got query %q", r.URL.RawQuery)
+		}
+		writeJSON(w, 201, map[string]interface{}{"status": true, "result": []interface{}{map[string]interface{}{
+			"status": true,
+			"result": map[string]interface{}{
+				"columns": []string{"id", "score", "name", "avatar"},
+				"types":   []string{"INTEGER", "REAL", "TEXT", "BLOB"},
+				"values":  [][]interface{}{{1, 1.5, "Alice", nil}, {2, 2.0, "Bob", "AQI="}},
+			},
+		}}})
+	})
+	c, err := New(Config{Nodes: []string{dead.URL, srv.URL}, Timeout: 5 * time.Second, Backoff: time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rows, err := c.Query(context.Background(), "db1", "SELECT * FROM users WHERE id > :id AND avatar != ?",
+		sql.Named("id", 0), []byte{1, 2})
+	if err != nil {
+		t.Fatalf("Query failed: %v", err)
+	}
+	if len(rows.Values) != 2 || rows.Values[0][0] != int64(1) || rows.Values[0][1] != 1.5 ||
+		rows.Values[0][2] != "Alice" || rows.Values[0][3] != nil || rows.Values[1][3] != "AQI=" {
+		t.Fatalf("got %v", rows.Values)
+	}
+
+	params := got["statements"].([]interface{})[0].(map[string]interface{})["params"].([]interface{})
+	want := []map[string]interface{}{
+		{"name": "id", "type": "int", "value": 0.0},
+		{"type": "blob", "value": "AQI="},
+	}
+	for i, p := range params {
+		p := p.(map[string]interface{})
+		for k, v := range want[i] {
+			if p[k] != v {
+				t.Errorf("param %d: got %s = %v, want %v", i, k, p[k], v)
+			}
+		}
+	}
+}
+
+func TestErrors(t *testing.T) {
+	_, srv := newFakeNode(t, "Leader", func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/db/missing/query":
+			writeJSON(w, 404, map[string]interface{}{"status": false, "error": "database not found"})
+		case "/db/db1/query":
+			var body map[string][]map[string]interface{}
+			json.NewDecoder(r.Body).Decode(&body)
+			if body["statements"][0]["q"] == "DELETE FROM t" {
+				writeJSON(w, 400, map[string]interface{}{"status": false, "error": "statement is not read-only, use /exec: DELETE", "result": []interface{}{
+					map[string]interface{}{"status": false, "error": "statement is not read-only, use /exec: DELETE"},
+				}})
+				return
+			}
+			writeJSON(w, 400, map[string]interface{}{"status": false, "error": "no such table: t", "result": []interface{}{
+				map[string]interface{}{"status": false, "error": "no such table: t"},
+			}})
+		case "/db/db1/exec":
+			writeJSON(w, 400, map[string]interface{}{"status": false, "message": "UNIQUE constraint failed: t.id", "result": map[string]interface{}{
+				"results": []map[string]interface{}{
+					{"rows_affected": 1},
+					{"code": 1555, "error": "UNIQUE constraint failed: t.id"},
+				},
+				"committed": false,
+			}})
+		}
+	})
+	c, err := New(Config{Nodes: []string{srv.URL}, Level: LevelStrong})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	if _, err := c.Query(ctx, "missing", "SELECT 1"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("got %v, want ErrNotFound", err)
+	}
+	var stmtErr *StatementError
+	if _, err := c.Query(ctx, "db1", "SELECT * FROM t"); !errors.As(err, &stmtErr) || stmtErr.Message != "no such table: t" {
+		t.Fatalf("got %v, want a statement error", err)
+	}
+	if _, err := c.Query(ctx, "db1", "DELETE FROM t"); !errors.Is(err, ErrNotReadOnly) {
+		t.Fatalf("got %v, want ErrNotReadOnly", err)
+	}
+	_, err = c.Batch(ctx, "db1", []Statement{
+		{SQL: "INSERT INTO t VALUES (?)", Args: []interface{}{1}},
+		{SQL: "INSERT INTO t VALUES (?)", Args: []interface{}{1}},
+	})
+	if !errors.As(err, &stmtErr) || stmtErr.Index != 1 || stmtErr.Code != 1555 {
+		t.Fatalf("got %v, want a statement error on the second statement", err)
+	}
+	if _, err := c.Exec(ctx, "db1", "INSERT INTO t VALUES (?)", struct{}{}); err == nil {
+		t.Fatal("expected an unsupported argument to fail")
+	}
+}
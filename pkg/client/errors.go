@@ -0,0 +1,85 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+var (
+	// ErrNotLeader is a write or leader read that reached a node that no
+	// longer leads the database
+	ErrNotLeader = errors.New("not leader")
+	// ErrNotFound is a database the cluster does not have
+	ErrNotFound = errors.New("database not found")
+	// ErrTimeout is a request that ran past its deadline
+	ErrTimeout = errors.New("request timed out")
+	// ErrNotReadOnly is a statement sent to Query that writes
+	ErrNotReadOnly = errors.New("statement is not read-only")
+	// ErrUnavailable is a cluster that could not serve the request, because
+	// it is busy or electing a leader
+	ErrUnavailable = errors.New("cluster unavailable")
+	// ErrOutcomeUnknown is a write the leader proposed before losing its
+	// leadership, it may or may not have been applied
+	ErrOutcomeUnknown = errors.New("write outcome unknown")
+)
+
+// Error is a request the server refused. errors.Is matches it against
+// the Err variables of this package.
+type Error struct {
+	StatusCode int
+	// Code is the machine-readable code of the error, "not_leader" or
+	// "leadership_lost", and empty for the others
+	Code    string
+	Message string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("rflite: %d %s: %s", e.StatusCode, http.StatusText(e.StatusCode), e.Message)
+}
+
+func (e *Error) Is(target error) bool {
+	switch target {
+	case ErrNotLeader:
+		return e.Code == "not_leader"
+	case ErrNotFound:
+		return e.StatusCode == http.StatusNotFound
+	case ErrTimeout:
+		return e.StatusCode == http.StatusGatewayTimeout
+	case ErrUnavailable:
+		return e.StatusCode == http.StatusServiceUnavailable || e.StatusCode == http.StatusBadGateway
+	case ErrOutcomeUnknown:
+		return e.Code == "leadership_lost"
+	}
+	return false
+}
+
+// retryable reports whether another attempt, possibly on another node,
+// may succeed. A write is only sent again when it was refused before the
+// leader proposed it: a failed proxy or a busy node may already have
+// applied it.
+func (e *Error) retryable(idempotent bool) bool {
+	if !idempotent {
+		return errors.Is(e, ErrNotLeader)
+	}
+	return e.StatusCode == http.StatusServiceUnavailable || e.StatusCode == http.StatusBadGateway
+}
+
+// StatementError is a statement the database rejected
+type StatementError struct {
+	// Index is the position of the statement in the request
+	Index int
+	// Code is the SQLite extended result code, 0 for queries
+	Code    int
+	Message string
+}
+
+func (e *StatementError) Error() string {
+	return fmt.Sprintf("rflite: statement %d: %s", e.Index, e.Message)
+}
+
+// Is matches a write sent to Query against ErrNotReadOnly
+func (e *StatementError) Is(target error) bool {
+	return target == ErrNotReadOnly && strings.HasPrefix(e.Message, "statement is not read-only")
+}
@@ -0,0 +1,119 @@
+package client
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// param is a statement argument in the typed form the server replicates,
+// {"type": ..., "value": ...}
+type param struct {
+	Name  string      `json:"name,omitempty"`
+	Type  string      `json:"type"`
+	Value interface{} `json:"value,omitempty"`
+}
+
+type statementJSON struct {
+	Q      string  `json:"q"`
+	Params []param `json:"params,omitempty"`
+}
+
+// statementsBody encodes stmts as the JSON body of /query and /exec
+func statementsBody(stmts []Statement) ([]byte, error) {
+	body := struct {
+		Statements []statementJSON `json:"statements"`
+	}{Statements: make([]statementJSON, len(stmts))}
+	for i, stmt := range stmts {
+		body.Statements[i].Q = stmt.SQL
+		for j, arg := range stmt.Args {
+			p, err := encodeArg(arg)
+			if err != nil {
+				return nil, fmt.Errorf("rflite: statement %d, arg %d: %w", i, j, err)
+			}
+			body.Statements[i].Params = append(body.Statements[i].Params, p)
+		}
+	}
+	return json.Marshal(body)
+}
+
+// encodeArg converts a Go value into a typed param. sql.Named gives the
+// param a name, bools become 0 or 1 and times RFC 3339 text, the way
+// go-sqlite3 binds them.
+func encodeArg(arg interface{}) (param, error) {
+	var name string
+	if named, ok := arg.(sql.NamedArg); ok {
+		name, arg = named.Name, named.Value
+	}
+	p := param{Name: name}
+	switch v := arg.(type) {
+	case nil:
+		p.Type = "null"
+	case int:
+		p.Type, p.Value = "int", int64(v)
+	case int8:
+		p.Type, p.Value = "int", int64(v)
+	case int16:
+		p.Type, p.Value = "int", int64(v)
+	case int32:
+		p.Type, p.Value = "int", int64(v)
+	case int64:
+		p.Type, p.Value = "int", v
+	case uint:
+		p.Type, p.Value = "int", uint64(v)
+	case uint8:
+		p.Type, p.Value = "int", uint64(v)
+	case uint16:
+		p.Type, p.Value = "int", uint64(v)
+	case uint32:
+		p.Type, p.Value = "int", uint64(v)
+	case uint64:
+		if v > 1<<63-1 {
+			return param{}, fmt.Errorf("uint64 %d overflows int64", v)
+		}
+		p.Type, p.Value = "int", v
+	case float32:
+		p.Type, p.Value = "float", float64(v)
+	case float64:
+		p.Type, p.Value = "float", v
+	case bool:
+		p.Type, p.Value = "int", 0
+		if v {
+			p.Value = 1
+		}
+	case string:
+		p.Type, p.Value = "text", v
+	case []byte:
+		p.Type, p.Value = "blob", base64.StdEncoding.EncodeToString(v)
+	case time.Time:
+		p.Type, p.Value = "text", v.Format(time.RFC3339Nano)
+	default:
+		return param{}, fmt.Errorf("unsupported type %T", arg)
+	}
+	return p, nil
+}
+
+// decodeValue converts a value of a columnar result: integers become
+// int64, other numbers float64
+func decodeValue(raw json.RawMessage) (interface{}, error) {
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+	n, ok := v.(json.Number)
+	if !ok {
+		return v, nil
+	}
+	if !strings.ContainsAny(n.String(), ".eE") {
+		if i, err := n.Int64(); err == nil {
+			return i, nil
+		}
+	}
+	return n.Float64()
+}
@@ -2,34 +2,64 @@ package pkg
 
 import (
 	"fmt"
-	"regexp"
-	"strings"
 )
 
 var ErrNoDatabase = fmt.Errorf("no USE statement found")
 
-// ParseUseQuery parses a SQL string like "USE db; SQL1; SQL2;"
-// and returns the database name and a slice of queries.
-func ParseUseQuery(sql string) (db string, queries []string, err error) {
-	re := regexp.MustCompile(`(?i)USE\s+([a-zA-Z0-9_]+)\s*;`)
+// UseBlock is a run of queries for one database, started by a USE
+// statement
+type UseBlock struct {
+	DB      string
+	Queries []string
+}
 
-	match := re.FindStringSubmatch(sql)
-	loc := re.FindStringIndex(sql) // loc[0] = start, loc[1] = end
-	if len(match) < 2 || loc == nil {
-		return "", nil, ErrNoDatabase
+// ParseUseQuery parses a SQL script like "USE db1; SQL1; SQL2; USE db2;
+// SQL3;" into the queries of every database, in script order. A query
+// before the first USE fails with ErrNoDatabase.
+func ParseUseQuery(sql string) ([]UseBlock, error) {
+	stmts, err := SplitStatements(sql)
+	if err != nil {
+		return nil, err
 	}
 
-	db = match[1]
-
-	rest := sql[loc[1]:]
-
-	stmts := strings.Split(rest, ";")
-	for _, s := range stmts {
-		s = strings.TrimSpace(s)
-		if s != "" {
-			queries = append(queries, s)
+	var blocks []UseBlock
+	for _, stmt := range stmts {
+		db, ok, err := stmt.UseTarget()
+		if err != nil {
+			return nil, err
 		}
+		if ok {
+			blocks = append(blocks, UseBlock{DB: db})
+			continue
+		}
+		if len(blocks) == 0 {
+			return nil, ErrNoDatabase
+		}
+		last := &blocks[len(blocks)-1]
+		last.Queries = append(last.Queries, stmt.SQL)
+	}
+	if len(blocks) == 0 {
+		return nil, ErrNoDatabase
 	}
+	return blocks, nil
+}
 
-	return db, queries, nil
+// UseTarget returns the database named by a USE statement, ok is false
+// for any other statement
+func (s Statement) UseTarget() (db string, ok bool, err error) {
+	tokens := s.tokens
+	if !tokens[0].Is("USE") {
+		return "", false, nil
+	}
+	if len(tokens) < 2 {
+		return "", true, &SyntaxError{Pos: s.Pos + len(s.SQL), Msg: "expected a database name after USE"}
+	}
+	name := tokens[1]
+	if name.Kind != TokenIdent && name.Kind != TokenQuotedIdent {
+		return "", true, &SyntaxError{Pos: name.Pos, Msg: fmt.Sprintf("expected a database name after USE, got %s", name.Text)}
+	}
+	if len(tokens) > 2 {
+		return "", true, &SyntaxError{Pos: tokens[2].Pos, Msg: fmt.Sprintf("unexpected %s after USE %s", tokens[2].Text, name.Text)}
+	}
+	return name.IdentValue(), true, nil
 }
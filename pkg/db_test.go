@@ -1,6 +1,7 @@
 package pkg
 
 import (
+	"errors"
 	"reflect"
 	"testing"
 )
@@ -9,74 +10,105 @@ func TestParseUseQuery(t *testing.T) {
 	tests := []struct {
 		name    string
 		sql     string
-		wantDB  string
-		wantQ   []string
+		want    []UseBlock
 		wantErr bool
 	}{
 		{
-			name:    "single query",
-			sql:     "USE mydb; SELECT * FROM foo;",
-			wantDB:  "mydb",
-			wantQ:   []string{"SELECT * FROM foo"},
-			wantErr: false,
+			name: "single query",
+			sql:  "USE mydb; SELECT * FROM foo;",
+			want: []UseBlock{{DB: "mydb", Queries: []string{"SELECT * FROM foo"}}},
 		},
 		{
-			name:    "multiple queries",
-			sql:     "USE mydb; SELECT * FROM foo; INSERT INTO bar VALUES (1); UPDATE bar SET id=2;",
-			wantDB:  "mydb",
-			wantQ:   []string{"SELECT * FROM foo", "INSERT INTO bar VALUES (1)", "UPDATE bar SET id=2"},
-			wantErr: false,
+			name: "multiple queries",
+			sql:  "USE mydb; SELECT * FROM foo; INSERT INTO bar VALUES (1); UPDATE bar SET id=2;",
+			want: []UseBlock{{DB: "mydb", Queries: []string{"SELECT * FROM foo", "INSERT INTO bar VALUES (1)", "UPDATE bar SET id=2"}}},
 		},
 		{
 			name:    "no USE statement",
 			sql:     "SELECT * FROM foo;",
-			wantDB:  "",
-			wantQ:   nil,
 			wantErr: true,
 		},
 		{
-			name:    "lowercase use",
-			sql:     "use testdb; SELECT 1;",
-			wantDB:  "testdb",
-			wantQ:   []string{"SELECT 1"},
-			wantErr: false,
+			name:    "query before USE",
+			sql:     "SELECT 1; USE mydb; SELECT 2;",
+			wantErr: true,
+		},
+		{
+			name: "lowercase use",
+			sql:  "use testdb; SELECT 1;",
+			want: []UseBlock{{DB: "testdb", Queries: []string{"SELECT 1"}}},
+		},
+		{
+			name: "extra spaces",
+			sql:  "  USE   dbname  ;   SELECT 1 ;  INSERT INTO t VALUES(2); ",
+			want: []UseBlock{{DB: "dbname", Queries: []string{"SELECT 1", "INSERT INTO t VALUES(2)"}}},
 		},
 		{
-			name:    "extra spaces",
-			sql:     "  USE   dbname  ;   SELECT 1 ;  INSERT INTO t VALUES(2); ",
-			wantDB:  "dbname",
-			wantQ:   []string{"SELECT 1", "INSERT INTO t VALUES(2)"},
-			wantErr: false,
+			name: "semicolon at end",
+			sql:  "USE dbname; SELECT 1",
+			want: []UseBlock{{DB: "dbname", Queries: []string{"SELECT 1"}}},
 		},
 		{
-			name:    "semicolon at end",
-			sql:     "USE dbname; SELECT 1",
-			wantDB:  "dbname",
-			wantQ:   []string{"SELECT 1"},
-			wantErr: false,
+			name: "only USE",
+			sql:  "USE mydb;",
+			want: []UseBlock{{DB: "mydb"}},
 		},
 		{
-			name:    "only USE",
-			sql:     "USE mydb;",
-			wantDB:  "mydb",
-			wantQ:   []string{},
-			wantErr: false,
+			name: "semicolons in literals and comments",
+			sql:  "USE mydb; -- first; db\nINSERT INTO t VALUES ('a;b', \"c;d\"); /* ; */ SELECT 1;",
+			want: []UseBlock{{DB: "mydb", Queries: []string{"INSERT INTO t VALUES ('a;b', \"c;d\")", "SELECT 1"}}},
+		},
+		{
+			name: "several USE",
+			sql:  "USE a; SELECT 1; USE \"b\"; SELECT 2; SELECT 3; USE a; SELECT 4;",
+			want: []UseBlock{
+				{DB: "a", Queries: []string{"SELECT 1"}},
+				{DB: "b", Queries: []string{"SELECT 2", "SELECT 3"}},
+				{DB: "a", Queries: []string{"SELECT 4"}},
+			},
+		},
+		{
+			name:    "USE without name",
+			sql:     "USE; SELECT 1;",
+			wantErr: true,
+		},
+		{
+			name:    "USE with extra tokens",
+			sql:     "USE a b; SELECT 1;",
+			wantErr: true,
 		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			db, q, err := ParseUseQuery(tt.sql)
+			blocks, err := ParseUseQuery(tt.sql)
 			if (err != nil) != tt.wantErr {
 				t.Fatalf("ParseUseQuery() error = %v, wantErr %v", err, tt.wantErr)
 			}
-			if db != tt.wantDB {
-				t.Errorf("ParseUseQuery() db = %v, want %v", db, tt.wantDB)
-			}
-
-			if len(tt.wantQ) != 0 && !reflect.DeepEqual(q, tt.wantQ) {
-				t.Errorf("ParseUseQuery() queries = %v, want %v", q, tt.wantQ)
+			if !reflect.DeepEqual(blocks, tt.want) {
+				t.Errorf("ParseUseQuery() = %#v, want %#v", blocks, tt.want)
 			}
 		})
 	}
 }
+
+func TestParseUseQueryErrors(t *testing.T) {
+	if _, err := ParseUseQuery("SELECT 1;"); !errors.Is(err, ErrNoDatabase) {
+		t.Fatalf("got %v, want ErrNoDatabase", err)
+	}
+	for sql, pos := range map[string]int{
+		"USE a b; SELECT 1;":        6,
+		"USE a; SELECT 'oops":       14,
+		"USE a; SELECT 1; USE (x);": 21,
+		"USE a; CREATE TRIGGER tr AFTER INSERT ON t BEGIN SELECT 1;": 7,
+	} {
+		_, err := ParseUseQuery(sql)
+		var syntaxErr *SyntaxError
+		if !errors.As(err, &syntaxErr) {
+			t.Fatalf("%q: got %v, want SyntaxError", sql, err)
+		}
+		if syntaxErr.Pos != pos {
+			t.Errorf("%q: got position %d, want %d", sql, syntaxErr.Pos, pos)
+		}
+	}
+}
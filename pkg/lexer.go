@@ -0,0 +1,221 @@
+package pkg
+
+import (
+	"fmt"
+	"strings"
+)
+
+// TokenKind classifies a SQL token
+type TokenKind int
+
+const (
+	TokenWhitespace TokenKind = iota
+	TokenComment
+	// TokenIdent is a bare identifier or keyword
+	TokenIdent
+	// TokenQuotedIdent is an identifier in "double quotes", `backticks`
+	// or [brackets]
+	TokenQuotedIdent
+	TokenString
+	TokenBlob
+	TokenNumber
+	// TokenParam is a bound parameter: ?, ?NNN, :name, @name or $name
+	TokenParam
+	TokenSemicolon
+	// TokenPunct is an operator, comma or parenthesis
+	TokenPunct
+)
+
+// Token is a piece of SQL text. Concatenating the Text of all tokens
+// gives back the input.
+type Token struct {
+	Kind TokenKind
+	Text string
+	// Pos is the byte offset of the token in the input
+	Pos int
+}
+
+// Is reports whether t is the bare identifier or keyword word, ignoring
+// case
+func (t Token) Is(word string) bool {
+	return t.Kind == TokenIdent && strings.EqualFold(t.Text, word)
+}
+
+// StringValue returns the value of a string literal with '' unescaped
+func (t Token) StringValue() string {
+	if t.Kind != TokenString || len(t.Text) < 2 {
+		return ""
+	}
+	return strings.ReplaceAll(t.Text[1:len(t.Text)-1], "''", "'")
+}
+
+// IdentValue returns the name of an identifier, without its quotes
+func (t Token) IdentValue() string {
+	if t.Kind != TokenQuotedIdent || len(t.Text) < 2 {
+		return t.Text
+	}
+	name := t.Text[1 : len(t.Text)-1]
+	if q := t.Text[:1]; q != "[" {
+		name = strings.ReplaceAll(name, q+q, q)
+	}
+	return name
+}
+
+// SyntaxError reports SQL that cannot be tokenized or split
+type SyntaxError struct {
+	Pos int
+	Msg string
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("syntax error at offset %d: %s", e.Pos, e.Msg)
+}
+
+// Tokenize splits sql into tokens following SQLite's quoting and comment
+// rules.
+func Tokenize(sql string) ([]Token, error) {
+	var tokens []Token
+	for i := 0; i < len(sql); {
+		start := i
+		kind, end, err := scanToken(sql, i)
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, Token{Kind: kind, Text: sql[start:end], Pos: start})
+		i = end
+	}
+	return tokens, nil
+}
+
+func scanToken(sql string, i int) (TokenKind, int, error) {
+	c := sql[i]
+	switch {
+	case isSpace(c):
+		j := i
+		for j < len(sql) && isSpace(sql[j]) {
+			j++
+		}
+		return TokenWhitespace, j, nil
+	case c == '-' && i+1 < len(sql) && sql[i+1] == '-':
+		j := strings.IndexByte(sql[i:], '\n')
+		if j < 0 {
+			return TokenComment, len(sql), nil
+		}
+		return TokenComment, i + j + 1, nil
+	case c == '/' && i+1 < len(sql) && sql[i+1] == '*':
+		j := strings.Index(sql[i+2:], "*/")
+		if j < 0 {
+			// SQLite accepts a block comment running to the end of input
+			return TokenComment, len(sql), nil
+		}
+		return TokenComment, i + 2 + j + 2, nil
+	case c == '\'':
+		end, err := scanQuoted(sql, i, '\'')
+		return TokenString, end, err
+	case c == '"' || c == '`':
+		end, err := scanQuoted(sql, i, c)
+		return TokenQuotedIdent, end, err
+	case c == '[':
+		j := strings.IndexByte(sql[i:], ']')
+		if j < 0 {
+			return 0, 0, &SyntaxError{Pos: i, Msg: "unterminated [identifier]"}
+		}
+		return TokenQuotedIdent, i + j + 1, nil
+	case (c == 'x' || c == 'X') && i+1 < len(sql) && sql[i+1] == '\'':
+		end, err := scanQuoted(sql, i+1, '\'')
+		return TokenBlob, end, err
+	case isDigit(c) || (c == '.' && i+1 < len(sql) && isDigit(sql[i+1])):
+		return TokenNumber, scanNumber(sql, i), nil
+	case c == '?':
+		j := i + 1
+		for j < len(sql) && isDigit(sql[j]) {
+			j++
+		}
+		return TokenParam, j, nil
+	case (c == ':' || c == '@' || c == '$') && i+1 < len(sql) && isIdentChar(sql[i+1]):
+		j := i + 1
+		for j < len(sql) && isIdentChar(sql[j]) {
+			j++
+		}
+		return TokenParam, j, nil
+	case isIdentStart(c):
+		j := i + 1
+		for j < len(sql) && (isIdentChar(sql[j]) || sql[j] == '$') {
+			j++
+		}
+		return TokenIdent, j, nil
+	case c == ';':
+		return TokenSemicolon, i + 1, nil
+	default:
+		return TokenPunct, i + 1, nil
+	}
+}
+
+// scanQuoted returns the end of a literal quoted with q starting at i,
+// where a doubled quote escapes it
+func scanQuoted(sql string, i int, q byte) (int, error) {
+	for j := i + 1; j < len(sql); j++ {
+		if sql[j] != q {
+			continue
+		}
+		if j+1 < len(sql) && sql[j+1] == q {
+			j++
+			continue
+		}
+		return j + 1, nil
+	}
+	return 0, &SyntaxError{Pos: i, Msg: fmt.Sprintf("unterminated %c quote", q)}
+}
+
+func scanNumber(sql string, i int) int {
+	if sql[i] == '0' && i+1 < len(sql) && (sql[i+1] == 'x' || sql[i+1] == 'X') {
+		j := i + 2
+		for j < len(sql) && isHexDigit(sql[j]) {
+			j++
+		}
+		return j
+	}
+	j := i
+	for j < len(sql) && (isDigit(sql[j]) || sql[j] == '_') {
+		j++
+	}
+	if j < len(sql) && sql[j] == '.' {
+		j++
+		for j < len(sql) && isDigit(sql[j]) {
+			j++
+		}
+	}
+	if j < len(sql) && (sql[j] == 'e' || sql[j] == 'E') {
+		k := j + 1
+		if k < len(sql) && (sql[k] == '+' || sql[k] == '-') {
+			k++
+		}
+		if k < len(sql) && isDigit(sql[k]) {
+			j = k
+			for j < len(sql) && isDigit(sql[j]) {
+				j++
+			}
+		}
+	}
+	return j
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f' || c == '\v'
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isHexDigit(c byte) bool {
+	return isDigit(c) || (c >= 'a' && c <= 'f') || (c >= 'A' && c <= 'F')
+}
+
+func isIdentStart(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || c >= 0x80
+}
+
+func isIdentChar(c byte) bool {
+	return isIdentStart(c) || isDigit(c)
+}
@@ -0,0 +1,66 @@
+package pkg
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestTokenize(t *testing.T) {
+	sql := "SELECT 'it''s; fine', \"a;b\", [c d], x'0A', 1.5e3 -- note; here\nFROM t /* ; */ WHERE id = :id;"
+	tokens, err := Tokenize(sql)
+	if err != nil {
+		t.Fatalf("Tokenize failed: %v", err)
+	}
+
+	var text strings.Builder
+	semicolons := 0
+	for _, tok := range tokens {
+		text.WriteString(tok.Text)
+		if tok.Kind == TokenSemicolon {
+			semicolons++
+		}
+	}
+	if text.String() != sql {
+		t.Fatalf("tokens do not round trip: %q", text.String())
+	}
+	if semicolons != 1 {
+		t.Fatalf("got %d semicolons, want 1", semicolons)
+	}
+
+	want := map[string]TokenKind{
+		"'it''s; fine'":   TokenString,
+		"\"a;b\"":         TokenQuotedIdent,
+		"[c d]":           TokenQuotedIdent,
+		"x'0A'":           TokenBlob,
+		"1.5e3":           TokenNumber,
+		"-- note; here\n": TokenComment,
+		"/* ; */":         TokenComment,
+		":id":             TokenParam,
+	}
+	for _, tok := range tokens {
+		if kind, ok := want[tok.Text]; ok {
+			if tok.Kind != kind {
+				t.Errorf("%q: got kind %d, want %d", tok.Text, tok.Kind, kind)
+			}
+			delete(want, tok.Text)
+		}
+	}
+	if len(want) != 0 {
+		t.Errorf("missing tokens: %v", want)
+	}
+	if got := tokens[2].StringValue(); got != "it's; fine" {
+		t.Errorf("StringValue() = %q", got)
+	}
+}
+
+func TestTokenizeUnterminated(t *testing.T) {
+	_, err := Tokenize("SELECT 1; SELECT 'oops")
+	var syntaxErr *SyntaxError
+	if !errors.As(err, &syntaxErr) {
+		t.Fatalf("got %v, want SyntaxError", err)
+	}
+	if syntaxErr.Pos != 17 {
+		t.Fatalf("got position %d, want 17", syntaxErr.Pos)
+	}
+}
@@ -0,0 +1,90 @@
+package pkg
+
+// Statement is one statement of a SQL script
+type Statement struct {
+	// SQL is the statement without its semicolon and the whitespace and
+	// comments around it
+	SQL string
+	// Pos is the byte offset of the statement in the script
+	Pos int
+	// tokens are the tokens that are neither whitespace nor comments
+	tokens []Token
+}
+
+// Tokens returns the tokens of the statement that are neither whitespace
+// nor comments
+func (s Statement) Tokens() []Token {
+	return s.tokens
+}
+
+// SplitStatements splits a script at the semicolons that end statements.
+// Semicolons in literals, identifiers, comments and the BEGIN ... END
+// body of a CREATE TRIGGER do not; empty statements are dropped.
+func SplitStatements(sql string) ([]Statement, error) {
+	tokens, err := Tokenize(sql)
+	if err != nil {
+		return nil, err
+	}
+
+	var stmts []Statement
+	var cur []Token
+	// trigger is set once cur is a CREATE TRIGGER, inBody while its
+	// body is open and caseDepth counts the CASE ... END inside it
+	trigger, inBody, caseDepth := false, false, 0
+	flush := func() {
+		if len(cur) > 0 {
+			first, last := cur[0], cur[len(cur)-1]
+			stmts = append(stmts, Statement{
+				SQL:    sql[first.Pos : last.Pos+len(last.Text)],
+				Pos:    first.Pos,
+				tokens: cur,
+			})
+		}
+		cur = nil
+		trigger, inBody, caseDepth = false, false, 0
+	}
+
+	for _, t := range tokens {
+		if t.Kind == TokenWhitespace || t.Kind == TokenComment {
+			continue
+		}
+		if t.Kind == TokenSemicolon && !inBody {
+			flush()
+			continue
+		}
+		cur = append(cur, t)
+		if !trigger {
+			trigger = isCreateTrigger(cur)
+			continue
+		}
+		switch {
+		case !inBody && t.Is("BEGIN"):
+			inBody = true
+		case inBody && t.Is("CASE"):
+			caseDepth++
+		case inBody && t.Is("END"):
+			if caseDepth > 0 {
+				caseDepth--
+			} else {
+				inBody = false
+			}
+		}
+	}
+	if inBody {
+		return nil, &SyntaxError{Pos: cur[0].Pos, Msg: "trigger body without END"}
+	}
+	flush()
+	return stmts, nil
+}
+
+// isCreateTrigger reports whether tokens start with CREATE [TEMP]
+// TRIGGER
+func isCreateTrigger(tokens []Token) bool {
+	if len(tokens) < 2 || !tokens[0].Is("CREATE") {
+		return false
+	}
+	if tokens[1].Is("TEMP") || tokens[1].Is("TEMPORARY") {
+		tokens = tokens[1:]
+	}
+	return len(tokens) >= 2 && tokens[1].Is("TRIGGER")
+}
@@ -0,0 +1,38 @@
+package pkg
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSplitStatements(t *testing.T) {
+	sql := `CREATE TABLE t (id INTEGER, note TEXT);
+CREATE TEMP TRIGGER tr AFTER INSERT ON t BEGIN
+	UPDATE t SET note = CASE WHEN new.id > 1 THEN 'big;' ELSE 'small' END WHERE id = new.id;
+	INSERT INTO log VALUES (new.id);
+END;
+-- trailing; comment
+;;SELECT [a;b] FROM t`
+	stmts, err := SplitStatements(sql)
+	if err != nil {
+		t.Fatalf("SplitStatements failed: %v", err)
+	}
+	var got []string
+	for _, stmt := range stmts {
+		got = append(got, stmt.SQL)
+		if sql[stmt.Pos:stmt.Pos+len(stmt.SQL)] != stmt.SQL {
+			t.Errorf("statement %q is not at offset %d", stmt.SQL, stmt.Pos)
+		}
+	}
+	want := []string{
+		"CREATE TABLE t (id INTEGER, note TEXT)",
+		`CREATE TEMP TRIGGER tr AFTER INSERT ON t BEGIN
+	UPDATE t SET note = CASE WHEN new.id > 1 THEN 'big;' ELSE 'small' END WHERE id = new.id;
+	INSERT INTO log VALUES (new.id);
+END`,
+		"SELECT [a;b] FROM t",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+}